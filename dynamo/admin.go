@@ -0,0 +1,385 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	klambda "github.com/kraneware/kws/lambda"
+)
+
+const pollInterval = time.Second
+
+// TableDef describes a table to create from a tagged model. Every secondary index projects all
+// attributes.
+type TableDef struct {
+	Name  string
+	Model interface{}
+
+	// BillingMode defaults to PAY_PER_REQUEST. The capacities apply to the table and its GSIs
+	// when it is PROVISIONED.
+	BillingMode   string
+	ReadCapacity  int64
+	WriteCapacity int64
+
+	// StreamViewType enables the table stream (e.g. NEW_AND_OLD_IMAGES) when set
+	StreamViewType string
+
+	// PruneIndexes deletes global secondary indexes the model does not declare. They are left
+	// in place and reported to Logger otherwise.
+	PruneIndexes bool
+
+	// Logger reports global secondary indexes the model does not declare when set
+	Logger *klambda.Klogger
+
+	// Client defaults to services.DynamoDbClient() when nil
+	Client dynamodbiface.DynamoDBAPI
+}
+
+// EnsureTable creates the table described by def and waits until it is ACTIVE. When the table
+// already exists, global secondary indexes that are missing or differ from the model are
+// created or replaced one at a time, and the stream and time to live settings are brought in
+// line. Indexes the model does not declare are only deleted with PruneIndexes. Local secondary
+// indexes cannot change after creation.
+func EnsureTable(ctx aws.Context, def TableDef) error {
+	s, err := SchemaOf(def.Model)
+	if err != nil {
+		return err
+	}
+
+	svc := clientOrDefault(def.Client)
+	if def.BillingMode == "" {
+		def.BillingMode = dynamodb.BillingModePayPerRequest
+	}
+
+	_, err = svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(def.Name)})
+	var nf *dynamodb.ResourceNotFoundException
+	switch {
+	case errors.As(err, &nf):
+		err = createTable(ctx, svc, def, s)
+	case err == nil:
+		err = updateTable(ctx, svc, def, s)
+	}
+
+	if err == nil && s.TTL != nil {
+		err = ensureTTL(ctx, svc, def.Name, s.TTL.Attribute)
+	}
+
+	return err
+}
+
+// DropTable deletes the table and waits until it is gone. A missing table is not an error. A
+// nil svc uses services.DynamoDbClient().
+func DropTable(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, name string) error {
+	svc = clientOrDefault(svc)
+
+	_, err := svc.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(name)})
+	var nf *dynamodb.ResourceNotFoundException
+	if errors.As(err, &nf) {
+		return nil
+	}
+	if err == nil {
+		err = svc.WaitUntilTableNotExistsWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)})
+	}
+
+	return err
+}
+
+func createTable(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, def TableDef, s *Schema) error {
+	in := &dynamodb.CreateTableInput{
+		TableName:             aws.String(def.Name),
+		BillingMode:           aws.String(def.BillingMode),
+		KeySchema:             keySchema(s.PartitionKey, s.SortKey),
+		AttributeDefinitions:  attributeDefinitions(s),
+		ProvisionedThroughput: throughput(def),
+	}
+
+	if def.StreamViewType != "" {
+		in.StreamSpecification = &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(def.StreamViewType),
+		}
+	}
+
+	for _, name := range indexNames(s) {
+		idx := s.Indexes[name]
+		if idx.Local {
+			in.LocalSecondaryIndexes = append(in.LocalSecondaryIndexes, &dynamodb.LocalSecondaryIndex{
+				IndexName:  aws.String(name),
+				KeySchema:  keySchema(idx.PartitionKey, idx.SortKey),
+				Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+			})
+		} else {
+			in.GlobalSecondaryIndexes = append(in.GlobalSecondaryIndexes, globalIndex(def, idx))
+		}
+	}
+
+	_, err := svc.CreateTableWithContext(ctx, in)
+	if err == nil {
+		err = svc.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(def.Name)})
+	}
+
+	return err
+}
+
+func updateTable(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, def TableDef, s *Schema) error {
+	desc, err := waitActive(ctx, svc, def.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, lsi := range desc.LocalSecondaryIndexes {
+		idx, ok := s.Indexes[aws.StringValue(lsi.IndexName)]
+		if !ok || !idx.Local || !sameKeys(lsi.KeySchema, idx) {
+			return fmt.Errorf("dynamo: local secondary index %s of %s differs from the model and cannot be changed",
+				aws.StringValue(lsi.IndexName), def.Name)
+		}
+	}
+
+	existing := make(map[string]*dynamodb.GlobalSecondaryIndexDescription, len(desc.GlobalSecondaryIndexes))
+	for _, gsi := range desc.GlobalSecondaryIndexes {
+		existing[aws.StringValue(gsi.IndexName)] = gsi
+	}
+
+	// DynamoDB accepts a single index creation or deletion per UpdateTable call
+	for name, gsi := range existing {
+		idx, ok := s.Indexes[name]
+		declared := ok && !idx.Local
+		if declared && sameKeys(gsi.KeySchema, idx) && projectsAll(gsi.Projection) {
+			continue
+		}
+		if !declared && !def.PruneIndexes {
+			if def.Logger != nil {
+				def.Logger.WithField("table", def.Name).WithField("index", name).
+					Warn("global secondary index is not declared by the model")
+			}
+			continue
+		}
+
+		delete(existing, name)
+		err = updateIndex(ctx, svc, def.Name, &dynamodb.GlobalSecondaryIndexUpdate{
+			Delete: &dynamodb.DeleteGlobalSecondaryIndexAction{IndexName: aws.String(name)},
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range indexNames(s) {
+		idx := s.Indexes[name]
+		if _, ok := existing[name]; ok || idx.Local {
+			continue
+		}
+
+		g := globalIndex(def, idx)
+		err = updateIndex(ctx, svc, def.Name, &dynamodb.GlobalSecondaryIndexUpdate{
+			Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+				IndexName:             g.IndexName,
+				KeySchema:             g.KeySchema,
+				Projection:            g.Projection,
+				ProvisionedThroughput: g.ProvisionedThroughput,
+			},
+		}, attributeDefinitions(s))
+		if err != nil {
+			return err
+		}
+	}
+
+	return ensureStream(ctx, svc, def, desc.StreamSpecification)
+}
+
+func updateIndex(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, table string, update *dynamodb.GlobalSecondaryIndexUpdate, attrs []*dynamodb.AttributeDefinition) error {
+	_, err := svc.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+		TableName:                   aws.String(table),
+		AttributeDefinitions:        attrs,
+		GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{update},
+	})
+	if err == nil {
+		_, err = waitActive(ctx, svc, table)
+	}
+
+	return err
+}
+
+func ensureStream(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, def TableDef, spec *dynamodb.StreamSpecification) error {
+	enabled := spec != nil && aws.BoolValue(spec.StreamEnabled)
+	if !enabled && def.StreamViewType == "" {
+		return nil
+	}
+	if enabled && aws.StringValue(spec.StreamViewType) == def.StreamViewType {
+		return nil
+	}
+
+	// the view type of an enabled stream can only change by disabling it first
+	if enabled {
+		err := updateStream(ctx, svc, def.Name, &dynamodb.StreamSpecification{StreamEnabled: aws.Bool(false)})
+		if err != nil || def.StreamViewType == "" {
+			return err
+		}
+	}
+
+	return updateStream(ctx, svc, def.Name, &dynamodb.StreamSpecification{
+		StreamEnabled:  aws.Bool(true),
+		StreamViewType: aws.String(def.StreamViewType),
+	})
+}
+
+func updateStream(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, table string, spec *dynamodb.StreamSpecification) error {
+	_, err := svc.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+		TableName:           aws.String(table),
+		StreamSpecification: spec,
+	})
+	if err == nil {
+		_, err = waitActive(ctx, svc, table)
+	}
+
+	return err
+}
+
+func ensureTTL(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, table, attr string) error {
+	out, err := svc.DescribeTimeToLiveWithContext(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(table)})
+	if err != nil {
+		return err
+	}
+
+	if d := out.TimeToLiveDescription; d != nil {
+		switch current := aws.StringValue(d.AttributeName); aws.StringValue(d.TimeToLiveStatus) {
+		case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+			if current == attr {
+				return nil
+			}
+			// DynamoDB only switches the attribute after disabling time to live, which can take
+			// up to an hour
+			return fmt.Errorf("dynamo: time to live of %s is enabled on %s instead of %s, disable it first",
+				table, current, attr)
+		case dynamodb.TimeToLiveStatusDisabling:
+			return fmt.Errorf("dynamo: time to live of %s is being disabled, retry once it is disabled", table)
+		}
+	}
+
+	_, err = svc.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(attr),
+			Enabled:       aws.Bool(true),
+		},
+	})
+
+	return err
+}
+
+// waitActive polls until the table and all of its global secondary indexes are ACTIVE
+func waitActive(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, table string) (*dynamodb.TableDescription, error) {
+	for {
+		out, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+		if err != nil {
+			return nil, err
+		}
+
+		active := aws.StringValue(out.Table.TableStatus) == dynamodb.TableStatusActive
+		for _, gsi := range out.Table.GlobalSecondaryIndexes {
+			active = active && aws.StringValue(gsi.IndexStatus) == dynamodb.IndexStatusActive
+		}
+		if active {
+			return out.Table, nil
+		}
+
+		if err = sleep(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func throughput(def TableDef) *dynamodb.ProvisionedThroughput {
+	if def.BillingMode != dynamodb.BillingModeProvisioned {
+		return nil
+	}
+
+	return &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(def.ReadCapacity),
+		WriteCapacityUnits: aws.Int64(def.WriteCapacity),
+	}
+}
+
+func globalIndex(def TableDef, idx *SecondaryIndex) *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName:             aws.String(idx.Name),
+		KeySchema:             keySchema(idx.PartitionKey, idx.SortKey),
+		Projection:            &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+		ProvisionedThroughput: throughput(def),
+	}
+}
+
+func keySchema(pk, sk *Field) []*dynamodb.KeySchemaElement {
+	ks := []*dynamodb.KeySchemaElement{{
+		AttributeName: aws.String(pk.Attribute),
+		KeyType:       aws.String(dynamodb.KeyTypeHash),
+	}}
+	if sk != nil {
+		ks = append(ks, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(sk.Attribute),
+			KeyType:       aws.String(dynamodb.KeyTypeRange),
+		})
+	}
+
+	return ks
+}
+
+// attributeDefinitions declares every attribute used as a key by the table or an index
+func attributeDefinitions(s *Schema) []*dynamodb.AttributeDefinition {
+	fields := []*Field{s.PartitionKey, s.SortKey}
+	for _, name := range indexNames(s) {
+		fields = append(fields, s.Indexes[name].PartitionKey, s.Indexes[name].SortKey)
+	}
+
+	var (
+		defs []*dynamodb.AttributeDefinition
+		seen = make(map[string]bool)
+	)
+	for _, f := range fields {
+		if f == nil || seen[f.Attribute] {
+			continue
+		}
+		seen[f.Attribute] = true
+		defs = append(defs, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(f.Attribute),
+			AttributeType: aws.String(f.ScalarType()),
+		})
+	}
+
+	return defs
+}
+
+func indexNames(s *Schema) []string {
+	names := make([]string, 0, len(s.Indexes))
+	for name := range s.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func sameKeys(ks []*dynamodb.KeySchemaElement, idx *SecondaryIndex) bool {
+	want := keySchema(idx.PartitionKey, idx.SortKey)
+	if len(ks) != len(want) {
+		return false
+	}
+
+	for i := range ks {
+		if aws.StringValue(ks[i].AttributeName) != aws.StringValue(want[i].AttributeName) ||
+			aws.StringValue(ks[i].KeyType) != aws.StringValue(want[i].KeyType) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func projectsAll(p *dynamodb.Projection) bool {
+	return p != nil && aws.StringValue(p.ProjectionType) == dynamodb.ProjectionTypeAll
+}
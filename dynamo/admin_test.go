@@ -0,0 +1,252 @@
+package dynamo_test
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/kraneware/kws/dynamo"
+	klambda "github.com/kraneware/kws/lambda"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// fakeAdmin keeps a single table description and applies updates immediately
+type fakeAdmin struct {
+	dynamodbiface.DynamoDBAPI
+
+	table   *dynamodb.TableDescription
+	ttl     *dynamodb.TimeToLiveSpecification
+	created *dynamodb.CreateTableInput
+	updates []*dynamodb.UpdateTableInput
+}
+
+func notFound() error {
+	return &dynamodb.ResourceNotFoundException{Message_: aws.String("not found")}
+}
+
+func (f *fakeAdmin) DescribeTableWithContext(aws.Context, *dynamodb.DescribeTableInput, ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	if f.table == nil {
+		return nil, notFound()
+	}
+
+	return &dynamodb.DescribeTableOutput{Table: f.table}, nil
+}
+
+func (f *fakeAdmin) CreateTableWithContext(_ aws.Context, in *dynamodb.CreateTableInput, _ ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	f.created = in
+	f.table = &dynamodb.TableDescription{
+		TableName:           in.TableName,
+		TableStatus:         aws.String(dynamodb.TableStatusActive),
+		KeySchema:           in.KeySchema,
+		StreamSpecification: in.StreamSpecification,
+	}
+	for _, g := range in.GlobalSecondaryIndexes {
+		f.table.GlobalSecondaryIndexes = append(f.table.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:   g.IndexName,
+			IndexStatus: aws.String(dynamodb.IndexStatusActive),
+			KeySchema:   g.KeySchema,
+			Projection:  g.Projection,
+		})
+	}
+	for _, l := range in.LocalSecondaryIndexes {
+		f.table.LocalSecondaryIndexes = append(f.table.LocalSecondaryIndexes, &dynamodb.LocalSecondaryIndexDescription{
+			IndexName: l.IndexName,
+			KeySchema: l.KeySchema,
+		})
+	}
+
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (f *fakeAdmin) UpdateTableWithContext(_ aws.Context, in *dynamodb.UpdateTableInput, _ ...request.Option) (*dynamodb.UpdateTableOutput, error) {
+	f.updates = append(f.updates, in)
+
+	if in.StreamSpecification != nil {
+		f.table.StreamSpecification = in.StreamSpecification
+	}
+
+	for _, u := range in.GlobalSecondaryIndexUpdates {
+		if u.Delete != nil {
+			var kept []*dynamodb.GlobalSecondaryIndexDescription
+			for _, g := range f.table.GlobalSecondaryIndexes {
+				if *g.IndexName != *u.Delete.IndexName {
+					kept = append(kept, g)
+				}
+			}
+			f.table.GlobalSecondaryIndexes = kept
+		}
+		if u.Create != nil {
+			f.table.GlobalSecondaryIndexes = append(f.table.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+				IndexName:   u.Create.IndexName,
+				IndexStatus: aws.String(dynamodb.IndexStatusActive),
+				KeySchema:   u.Create.KeySchema,
+				Projection:  u.Create.Projection,
+			})
+		}
+	}
+
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+func (f *fakeAdmin) DeleteTableWithContext(aws.Context, *dynamodb.DeleteTableInput, ...request.Option) (*dynamodb.DeleteTableOutput, error) {
+	if f.table == nil {
+		return nil, notFound()
+	}
+	f.table = nil
+
+	return &dynamodb.DeleteTableOutput{}, nil
+}
+
+func (f *fakeAdmin) DescribeTimeToLiveWithContext(aws.Context, *dynamodb.DescribeTimeToLiveInput, ...request.Option) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	out := &dynamodb.DescribeTimeToLiveOutput{}
+	if f.ttl != nil {
+		out.TimeToLiveDescription = &dynamodb.TimeToLiveDescription{
+			AttributeName:    f.ttl.AttributeName,
+			TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusEnabled),
+		}
+	}
+
+	return out, nil
+}
+
+func (f *fakeAdmin) UpdateTimeToLiveWithContext(_ aws.Context, in *dynamodb.UpdateTimeToLiveInput, _ ...request.Option) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	f.ttl = in.TimeToLiveSpecification
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func (f *fakeAdmin) WaitUntilTableExistsWithContext(aws.Context, *dynamodb.DescribeTableInput, ...request.WaiterOption) error {
+	return nil
+}
+
+func (f *fakeAdmin) WaitUntilTableNotExistsWithContext(aws.Context, *dynamodb.DescribeTableInput, ...request.WaiterOption) error {
+	return nil
+}
+
+type orderV2 struct {
+	Customer string `json:"customer" dynamo:"pk"`
+	ID       string `json:"id" dynamo:"sk"`
+	Status   string `json:"status" dynamo:"gsi=ByStatus"`
+	Created  string `json:"created" dynamo:"gsi=ByStatus:sk"`
+	Total    int    `json:"total" dynamo:"lsi=ByTotal,gsi=ByTotalOnly"`
+	Expires  int64  `json:"expires,omitempty" dynamo:"ttl"`
+}
+
+var _ = Describe("Table administration", func() {
+	var (
+		ctx  context.Context
+		fake *fakeAdmin
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = &fakeAdmin{}
+	})
+
+	It("should create a table from the model", func() {
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{
+			Name:           "orders",
+			Model:          order{},
+			StreamViewType: dynamodb.StreamViewTypeNewAndOldImages,
+			Client:         fake,
+		})).Should(BeNil())
+
+		in := fake.created
+		Expect(*in.BillingMode).Should(Equal(dynamodb.BillingModePayPerRequest))
+		Expect(in.ProvisionedThroughput).Should(BeNil())
+		Expect(in.KeySchema).Should(HaveLen(2))
+		Expect(*in.KeySchema[1].AttributeName).Should(Equal("id"))
+		Expect(in.AttributeDefinitions).Should(HaveLen(4))
+		Expect(in.GlobalSecondaryIndexes).Should(HaveLen(1))
+		Expect(*in.LocalSecondaryIndexes[0].KeySchema[0].AttributeName).Should(Equal("customer"))
+		Expect(*in.StreamSpecification.StreamEnabled).Should(BeTrue())
+		Expect(*fake.ttl.AttributeName).Should(Equal("expires"))
+
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{
+			Name:           "orders",
+			Model:          order{},
+			StreamViewType: dynamodb.StreamViewTypeNewAndOldImages,
+			Client:         fake,
+		})).Should(BeNil())
+		Expect(fake.updates).Should(BeEmpty())
+	})
+
+	It("should set provisioned throughput", func() {
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{
+			Name:          "orders",
+			Model:         order{},
+			BillingMode:   dynamodb.BillingModeProvisioned,
+			ReadCapacity:  5,
+			WriteCapacity: 2,
+			Client:        fake,
+		})).Should(BeNil())
+		Expect(*fake.created.ProvisionedThroughput.ReadCapacityUnits).Should(Equal(int64(5)))
+		Expect(*fake.created.GlobalSecondaryIndexes[0].ProvisionedThroughput.WriteCapacityUnits).Should(Equal(int64(2)))
+	})
+
+	It("should replace differing global indexes one at a time", func() {
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{Name: "orders", Model: order{}, Client: fake})).Should(BeNil())
+
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{
+			Name:           "orders",
+			Model:          orderV2{},
+			StreamViewType: dynamodb.StreamViewTypeKeysOnly,
+			Client:         fake,
+		})).Should(BeNil())
+
+		// delete ByStatus, create ByStatus and ByTotalOnly, enable the stream
+		Expect(fake.updates).Should(HaveLen(4))
+		Expect(fake.updates[0].GlobalSecondaryIndexUpdates[0].Delete).ShouldNot(BeNil())
+		Expect(*fake.updates[1].GlobalSecondaryIndexUpdates[0].Create.IndexName).Should(Equal("ByStatus"))
+		Expect(*fake.updates[2].GlobalSecondaryIndexUpdates[0].Create.IndexName).Should(Equal("ByTotalOnly"))
+		Expect(fake.updates[2].AttributeDefinitions).Should(HaveLen(5))
+		Expect(*fake.updates[3].StreamSpecification.StreamViewType).Should(Equal(dynamodb.StreamViewTypeKeysOnly))
+		Expect(fake.table.GlobalSecondaryIndexes).Should(HaveLen(2))
+	})
+
+	It("should only delete undeclared global indexes when pruning", func() {
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{Name: "orders", Model: order{}, Client: fake})).Should(BeNil())
+		fake.table.GlobalSecondaryIndexes = append(fake.table.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:   aws.String("Manual"),
+			IndexStatus: aws.String(dynamodb.IndexStatusActive),
+		})
+
+		base, hook := test.NewNullLogger()
+		def := dynamo.TableDef{Name: "orders", Model: order{}, Client: fake, Logger: klambda.LambdaLoggerCustom(logrus.InfoLevel, base)}
+		Expect(dynamo.EnsureTable(ctx, def)).Should(BeNil())
+		Expect(fake.updates).Should(BeEmpty())
+		Expect(fake.table.GlobalSecondaryIndexes).Should(HaveLen(2))
+		Expect(hook.LastEntry().Data["index"]).Should(Equal("Manual"))
+
+		def.PruneIndexes = true
+		Expect(dynamo.EnsureTable(ctx, def)).Should(BeNil())
+		Expect(fake.updates).Should(HaveLen(1))
+		Expect(*fake.updates[0].GlobalSecondaryIndexUpdates[0].Delete.IndexName).Should(Equal("Manual"))
+		Expect(fake.table.GlobalSecondaryIndexes).Should(HaveLen(1))
+	})
+
+	It("should explain a time to live enabled on another attribute", func() {
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{Name: "orders", Model: order{}, Client: fake})).Should(BeNil())
+		fake.ttl.AttributeName = aws.String("deleteAt")
+
+		err := dynamo.EnsureTable(ctx, dynamo.TableDef{Name: "orders", Model: order{}, Client: fake})
+		Expect(err).Should(MatchError(ContainSubstring("enabled on deleteAt instead of expires")))
+		Expect(*fake.ttl.AttributeName).Should(Equal("deleteAt"))
+	})
+
+	It("should refuse to change local indexes", func() {
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{Name: "orders", Model: order{}, Client: fake})).Should(BeNil())
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{Name: "orders", Model: account{}, Client: fake})).ShouldNot(BeNil())
+	})
+
+	It("should drop tables", func() {
+		Expect(dynamo.EnsureTable(ctx, dynamo.TableDef{Name: "orders", Model: order{}, Client: fake})).Should(BeNil())
+		Expect(dynamo.DropTable(ctx, fake, "orders")).Should(BeNil())
+		Expect(fake.table).Should(BeNil())
+		Expect(dynamo.DropTable(ctx, fake, "orders")).Should(BeNil())
+	})
+})
@@ -0,0 +1,372 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/kraneware/kws/services"
+)
+
+const (
+	// MaxBatchWriteItems is the BatchWriteItem limit per request
+	MaxBatchWriteItems = 25
+
+	// MaxBatchGetKeys is the BatchGetItem limit per request
+	MaxBatchGetKeys = 100
+
+	maxBackoff = 5 * time.Second
+)
+
+// BatchReport summarises a chunked batch operation. Requests and keys listed here were still
+// unprocessed after every retry.
+type BatchReport struct {
+	Processed       int
+	UnprocessedPuts []map[string]*dynamodb.AttributeValue
+	UnprocessedDels []map[string]*dynamodb.AttributeValue
+	UnprocessedKeys []map[string]*dynamodb.AttributeValue
+}
+
+// Failed returns the number of items that could not be processed
+func (r *BatchReport) Failed() int {
+	return len(r.UnprocessedPuts) + len(r.UnprocessedDels) + len(r.UnprocessedKeys)
+}
+
+func (r *BatchReport) addWrites(reqs []*dynamodb.WriteRequest) {
+	for _, w := range reqs {
+		if w.PutRequest != nil {
+			r.UnprocessedPuts = append(r.UnprocessedPuts, w.PutRequest.Item)
+		} else if w.DeleteRequest != nil {
+			r.UnprocessedDels = append(r.UnprocessedDels, w.DeleteRequest.Key)
+		}
+	}
+}
+
+func clientOrDefault(svc dynamodbiface.DynamoDBAPI) dynamodbiface.DynamoDBAPI {
+	if svc != nil {
+		return svc
+	}
+
+	return services.DynamoDbClient()
+}
+
+// BatchWrite sends any number of write requests to the table in chunks of 25 with bounded
+// concurrency, retrying unprocessed items with exponential backoff. A nil svc uses
+// services.DynamoDbClient(). DynamoDB rejects a chunk holding two requests for the same key;
+// Table.BatchPut and Table.BatchDelete remove such duplicates.
+func BatchWrite(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, table string, reqs []*dynamodb.WriteRequest, opts ...Option) (*BatchReport, error) {
+	svc = clientOrDefault(svc)
+	o := applyOptions(opts)
+	report := &BatchReport{}
+
+	var mu sync.Mutex
+	err := runChunks(ctx, len(reqs), MaxBatchWriteItems, o.concurrency, func(ctx aws.Context, from, to int) error {
+		pending := reqs[from:to]
+
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt > o.retries {
+					break
+				}
+				if err := sleep(ctx, backoff(o.backoff, attempt)); err != nil {
+					return err
+				}
+			}
+
+			out, err := svc.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]*dynamodb.WriteRequest{table: pending},
+			})
+			if err != nil {
+				if isThrottle(err) {
+					continue
+				}
+				return err
+			}
+
+			done := len(pending) - len(out.UnprocessedItems[table])
+			pending = out.UnprocessedItems[table]
+
+			mu.Lock()
+			report.Processed += done
+			mu.Unlock()
+		}
+
+		mu.Lock()
+		report.addWrites(pending)
+		mu.Unlock()
+
+		return nil
+	})
+
+	return report, err
+}
+
+// BatchGet reads any number of keys from the table in chunks of 100 with bounded concurrency,
+// retrying unprocessed keys with exponential backoff. A nil svc uses services.DynamoDbClient().
+func BatchGet(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, table string, keys []map[string]*dynamodb.AttributeValue, opts ...Option) ([]map[string]*dynamodb.AttributeValue, *BatchReport, error) {
+	svc = clientOrDefault(svc)
+	o := applyOptions(opts)
+	report := &BatchReport{}
+
+	var (
+		mu    sync.Mutex
+		items []map[string]*dynamodb.AttributeValue
+	)
+	err := runChunks(ctx, len(keys), MaxBatchGetKeys, o.concurrency, func(ctx aws.Context, from, to int) error {
+		pending := keys[from:to]
+
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt > o.retries {
+					break
+				}
+				if err := sleep(ctx, backoff(o.backoff, attempt)); err != nil {
+					return err
+				}
+			}
+
+			out, err := svc.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]*dynamodb.KeysAndAttributes{
+					table: {Keys: pending, ConsistentRead: aws.Bool(o.consistent)},
+				},
+			})
+			if err != nil {
+				if isThrottle(err) {
+					continue
+				}
+				return err
+			}
+
+			pending = nil
+			if u, ok := out.UnprocessedKeys[table]; ok && u != nil {
+				pending = u.Keys
+			}
+
+			mu.Lock()
+			items = append(items, out.Responses[table]...)
+			report.Processed += len(out.Responses[table])
+			mu.Unlock()
+		}
+
+		mu.Lock()
+		report.UnprocessedKeys = append(report.UnprocessedKeys, pending...)
+		mu.Unlock()
+
+		return nil
+	})
+
+	return items, report, err
+}
+
+// BatchPut writes every item of the given slice of models. When several items have the same
+// key only the last one is written. Unlike Put, BatchPut neither checks nor increments the
+// version field: BatchWriteItem does not support conditions, so use Put or a WriteTx for
+// versioned models.
+func (t *Table) BatchPut(ctx aws.Context, items interface{}, opts ...Option) (*BatchReport, error) {
+	var reqs []*dynamodb.WriteRequest
+	seen := make(map[string]int)
+	err := t.eachItem(items, func(v reflect.Value) error {
+		key, err := t.keyOf(v)
+		var av map[string]*dynamodb.AttributeValue
+		if err == nil {
+			av, err = t.marshalItem(v)
+		}
+		if err == nil {
+			reqs = addUnique(reqs, seen, t.keyID(key), &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: av}})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return BatchWrite(ctx, t.client(), t.Name, reqs, opts...)
+}
+
+// BatchDelete deletes every item identified by the key fields of the given slice of models,
+// ignoring repeated keys
+func (t *Table) BatchDelete(ctx aws.Context, items interface{}, opts ...Option) (*BatchReport, error) {
+	var reqs []*dynamodb.WriteRequest
+	seen := make(map[string]int)
+	err := t.eachItem(items, func(v reflect.Value) error {
+		key, err := t.keyOf(v)
+		if err == nil {
+			reqs = addUnique(reqs, seen, t.keyID(key), &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: key}})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return BatchWrite(ctx, t.client(), t.Name, reqs, opts...)
+}
+
+// BatchGet loads the items identified by the key fields of the given slice of models into out
+// (a pointer to a slice of the model). Items that do not exist are omitted and repeated keys
+// are read once.
+func (t *Table) BatchGet(ctx aws.Context, keys interface{}, out interface{}, opts ...Option) (*BatchReport, error) {
+	var avs []map[string]*dynamodb.AttributeValue
+	seen := make(map[string]bool)
+	err := t.eachItem(keys, func(v reflect.Value) error {
+		key, err := t.keyOf(v)
+		if id := t.keyID(key); err == nil && !seen[id] {
+			seen[id] = true
+			avs = append(avs, key)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items, report, err := BatchGet(ctx, t.client(), t.Name, avs, opts...)
+	if err == nil {
+		err = dynamodbattribute.UnmarshalListOfMaps(items, out)
+	}
+
+	return report, err
+}
+
+// keyID identifies an item by the values of its key attributes
+func (t *Table) keyID(key map[string]*dynamodb.AttributeValue) string {
+	id := ""
+	for _, f := range []*Field{t.Schema.PartitionKey, t.Schema.SortKey} {
+		if f != nil {
+			id += key[f.Attribute].String() + "|"
+		}
+	}
+
+	return id
+}
+
+// addUnique appends req, or replaces the earlier request with the same key: DynamoDB rejects
+// a BatchWriteItem request with duplicate keys
+func addUnique(reqs []*dynamodb.WriteRequest, seen map[string]int, id string, req *dynamodb.WriteRequest) []*dynamodb.WriteRequest {
+	if i, ok := seen[id]; ok {
+		reqs[i] = req
+		return reqs
+	}
+	seen[id] = len(reqs)
+
+	return append(reqs, req)
+}
+
+func (t *Table) eachItem(items interface{}, fn func(reflect.Value) error) error {
+	s := reflect.ValueOf(items)
+	if s.Kind() != reflect.Slice && s.Kind() != reflect.Array {
+		return errors.New("dynamo: batch items must be a slice")
+	}
+
+	for i := 0; i < s.Len(); i++ {
+		v, err := t.structValue(s.Index(i).Interface())
+		if err == nil {
+			err = fn(v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runChunks splits n items in chunks of size and runs them with at most concurrency workers.
+// The first error cancels the remaining chunks.
+func runChunks(ctx aws.Context, n, size, concurrency int, fn func(ctx aws.Context, from, to int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+	)
+
+	for from := 0; from < n; from += size {
+		to := from + size
+		if to > n {
+			to = n
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(ctx, from, to); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(from, to)
+	}
+
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+
+	return firstErr
+}
+
+// backoff returns a jittered exponential delay for the given attempt, or none for a zero base
+func backoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	d := base << uint(attempt-1)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) // nolint:gosec
+}
+
+func sleep(ctx aws.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isThrottle(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	switch aerr.Code() {
+	case dynamodb.ErrCodeProvisionedThroughputExceededException,
+		dynamodb.ErrCodeRequestLimitExceeded,
+		"ThrottlingException":
+		return true
+	}
+
+	return false
+}
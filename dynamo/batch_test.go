@@ -0,0 +1,110 @@
+package dynamo_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch", func() {
+	var (
+		ctx   context.Context
+		fake  *fakeDynamo
+		table *dynamo.Table
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		fake = newFakeDynamo("customer", "id")
+		table, err = dynamo.NewTable("orders", order{})
+		Expect(err).Should(BeNil())
+		table.Client = fake
+	})
+
+	orders := func(n int) []order {
+		out := make([]order, n)
+		for i := range out {
+			out[i] = order{Customer: "c1", ID: fmt.Sprintf("o%03d", i), Total: i}
+		}
+		return out
+	}
+
+	It("should chunk writes and retry unprocessed items", func() {
+		fake.unprocessed = 3
+		report, err := table.BatchPut(ctx, orders(110), dynamo.Concurrency(2), dynamo.Backoff(time.Millisecond))
+		Expect(err).Should(BeNil())
+		Expect(report.Processed).Should(Equal(110))
+		Expect(report.Failed()).Should(Equal(0))
+		Expect(fake.items).Should(HaveLen(110))
+		Expect(fake.calls["BatchWriteItem"]).Should(Equal(8))
+	})
+
+	It("should chunk reads and decode typed results", func() {
+		_, err := table.BatchPut(ctx, orders(150))
+		Expect(err).Should(BeNil())
+
+		fake.unprocessed = 1
+		var out []order
+		report, err := table.BatchGet(ctx, orders(150), &out, dynamo.Backoff(time.Millisecond))
+		Expect(err).Should(BeNil())
+		Expect(report.Processed).Should(Equal(150))
+		Expect(out).Should(HaveLen(150))
+		Expect(fake.calls["BatchGetItem"]).Should(Equal(3))
+	})
+
+	It("should report items still unprocessed after the retries", func() {
+		fake.unprocessed = 100
+		report, err := table.BatchDelete(ctx, orders(3), dynamo.Retries(2), dynamo.Backoff(time.Millisecond))
+		Expect(err).Should(BeNil())
+		Expect(report.Processed).Should(Equal(2))
+		Expect(report.UnprocessedDels).Should(HaveLen(1))
+		Expect(report.Failed()).Should(Equal(1))
+	})
+
+	It("should write the last of repeated keys", func() {
+		items := append(orders(3), order{Customer: "c1", ID: "o001", Total: 100})
+		report, err := table.BatchPut(ctx, items)
+		Expect(err).Should(BeNil())
+		Expect(report.Processed).Should(Equal(3))
+
+		got := order{Customer: "c1", ID: "o001"}
+		Expect(table.Get(ctx, &got)).Should(BeNil())
+		Expect(got.Total).Should(Equal(100))
+
+		_, err = table.BatchDelete(ctx, append(orders(2), orders(2)...))
+		Expect(err).Should(BeNil())
+		Expect(fake.items).Should(HaveLen(1))
+
+		var out []order
+		_, err = table.BatchGet(ctx, append(orders(3), orders(3)...), &out)
+		Expect(err).Should(BeNil())
+		Expect(out).Should(HaveLen(1))
+	})
+
+	It("should retry immediately without backoff", func() {
+		fake.unprocessed = 5
+		start := time.Now()
+		report, err := table.BatchPut(ctx, orders(3), dynamo.Backoff(0))
+		Expect(err).Should(BeNil())
+		Expect(report.Processed).Should(Equal(3))
+		Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+
+	It("should reject invalid input and honour cancellation", func() {
+		_, err := table.BatchPut(ctx, order{})
+		Expect(err).ShouldNot(BeNil())
+
+		_, err = table.BatchPut(ctx, []order{{ID: "missing customer"}})
+		Expect(err).ShouldNot(BeNil())
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = table.BatchPut(cctx, orders(30))
+		Expect(err).Should(Equal(context.Canceled))
+	})
+})
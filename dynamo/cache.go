@@ -0,0 +1,203 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/kraneware/kws/internal/cache"
+	klambda "github.com/kraneware/kws/lambda"
+)
+
+const (
+	cacheValueAttribute   = "value"
+	cacheMissingAttribute = "missing"
+	cacheExpiresAttribute = "expires"
+)
+
+// Loader computes the value of a cache key on a miss. Returning ErrNotFound caches the absence
+// of the value for the negative TTL.
+type Loader func(ctx context.Context) (interface{}, error)
+
+// Cache is a read-through cache shared by Lambda containers. Each container keeps recently used
+// entries in memory in front of a DynamoDB table whose "expires" attribute should be enabled as
+// the TTL attribute. Concurrent misses of the same key in a container share a single load.
+//
+// Example:
+//
+//	rates := dynamo.NewCache("cache", 10*time.Minute)
+//	var rate exchangeRate
+//	err := rates.Get(ctx, "rate#EUR", &rate, func(ctx context.Context) (interface{}, error) {
+//		return fetchRate(ctx, "EUR")
+//	})
+type Cache struct {
+	Table string
+
+	// PartitionKey is the string key attribute of the table, "id" by default
+	PartitionKey string
+
+	// TTL is how long loaded values are served, NegativeTTL how long a missing value is
+	// remembered (one minute by default)
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	// Size bounds the entries kept in memory, 1000 by default
+	Size int
+
+	// Logger reports failures to store loaded values when set
+	Logger *klambda.Klogger
+
+	// Client defaults to services.DynamoDbClient() when nil
+	Client dynamodbiface.DynamoDBAPI
+
+	once   sync.Once
+	local  *cache.LRU
+	flight cache.Group
+}
+
+type cached struct {
+	value   *dynamodb.AttributeValue
+	expires time.Time
+}
+
+// NewCache creates a cache on the given table serving values for ttl
+func NewCache(table string, ttl time.Duration) *Cache {
+	return &Cache{
+		Table:        table,
+		PartitionKey: "id",
+		TTL:          ttl,
+		NegativeTTL:  time.Minute,
+		Size:         1000,
+	}
+}
+
+func (c *Cache) key(name string) map[string]*dynamodb.AttributeValue {
+	pk := c.PartitionKey
+	if pk == "" {
+		pk = "id"
+	}
+
+	return map[string]*dynamodb.AttributeValue{pk: {S: aws.String(name)}}
+}
+
+func (c *Cache) memory() *cache.LRU {
+	c.once.Do(func() {
+		c.local = cache.NewLRU(c.Size)
+	})
+
+	return c.local
+}
+
+// Get unmarshals the value of key into out, a pointer. On a miss in memory and in the table
+// the loader is called and its result stored in both. ErrNotFound is returned while the value
+// is known to be missing; other loader errors are returned without being cached.
+func (c *Cache) Get(ctx context.Context, key string, out interface{}, loader Loader) error {
+	local := c.memory()
+	if v, ok := local.Get(key); ok {
+		if e := v.(*cached); time.Now().Before(e.expires) {
+			return decodeCached(e, out)
+		}
+		local.Remove(key)
+	}
+
+	v, err := c.flight.Do(key, func() (interface{}, error) {
+		e, err := c.fetch(ctx, key)
+		if err == nil && e == nil {
+			e, err = c.load(ctx, key, loader)
+		}
+		if err == nil {
+			local.Add(key, e)
+		}
+		return e, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return decodeCached(v.(*cached), out)
+}
+
+// Invalidate removes key from the table and from the memory of this container. Other
+// containers keep serving their copy until it expires.
+func (c *Cache) Invalidate(ctx context.Context, key string) error {
+	c.memory().Remove(key)
+
+	_, err := clientOrDefault(c.Client).DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(c.Table),
+		Key:       c.key(key),
+	})
+
+	return err
+}
+
+// fetch returns the unexpired table entry of key, or nil
+func (c *Cache) fetch(ctx context.Context, key string) (*cached, error) {
+	out, err := clientOrDefault(c.Client).GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(c.Table),
+		Key:       c.key(key),
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+
+	// TTL deletes expired items lazily, so they can still be read for a while
+	expires, err := numberAttribute(out.Item, cacheExpiresAttribute)
+	if err != nil || expires <= time.Now().Unix() {
+		return nil, err
+	}
+
+	e := &cached{expires: time.Unix(expires, 0)}
+	if missing := out.Item[cacheMissingAttribute]; missing == nil || !aws.BoolValue(missing.BOOL) {
+		e.value = out.Item[cacheValueAttribute]
+		if e.value == nil {
+			e.value = &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+		}
+	}
+
+	return e, nil
+}
+
+func (c *Cache) load(ctx context.Context, key string, loader Loader) (*cached, error) {
+	v, err := loader(ctx)
+
+	item := c.key(key)
+	ttl := c.TTL
+	switch {
+	case errors.Is(err, ErrNotFound):
+		ttl = c.NegativeTTL
+		item[cacheMissingAttribute] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	case err != nil:
+		return nil, err
+	default:
+		if item[cacheValueAttribute], err = dynamodbattribute.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	e := &cached{value: item[cacheValueAttribute], expires: time.Now().Add(ttl)}
+	item[cacheExpiresAttribute] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(e.expires.Unix(), 10))}
+
+	// the loaded value is still served when it cannot be shared with other containers
+	if _, err := clientOrDefault(c.Client).PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.Table),
+		Item:      item,
+	}); err != nil && c.Logger != nil {
+		c.Logger.WithField("error", err).WithField("key", key).Warn("failed to store cache entry")
+	}
+
+	return e, nil
+}
+
+func decodeCached(e *cached, out interface{}) error {
+	if e.value == nil {
+		return ErrNotFound
+	}
+
+	return dynamodbattribute.Unmarshal(e.value, out)
+}
@@ -0,0 +1,157 @@
+package dynamo_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type rate struct {
+	Currency string  `json:"currency"`
+	Value    float64 `json:"value"`
+}
+
+var _ = Describe("Cache", func() {
+	var (
+		ctx   context.Context
+		fake  *fakeDynamo
+		c     *dynamo.Cache
+		loads int32
+	)
+
+	newCache := func() *dynamo.Cache {
+		c := dynamo.NewCache("cache", time.Hour)
+		c.Client = fake
+		return c
+	}
+
+	loader := func(v interface{}, err error) dynamo.Loader {
+		return func(context.Context) (interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			return v, err
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeDynamo("id")
+		c = newCache()
+		loads = 0
+	})
+
+	It("should load once and serve from memory and from the table", func() {
+		var r rate
+		Expect(c.Get(ctx, "EUR", &r, loader(rate{"EUR", 1.1}, nil))).Should(BeNil())
+		Expect(r).Should(Equal(rate{"EUR", 1.1}))
+		Expect(fake.lastPut.Item).Should(HaveKey("expires"))
+
+		r = rate{}
+		Expect(c.Get(ctx, "EUR", &r, loader(nil, nil))).Should(BeNil())
+		Expect(r.Value).Should(Equal(1.1))
+		Expect(fake.count("GetItem")).Should(Equal(1))
+
+		// another container finds the value in the table
+		r = rate{}
+		Expect(newCache().Get(ctx, "EUR", &r, loader(nil, nil))).Should(BeNil())
+		Expect(r.Value).Should(Equal(1.1))
+		Expect(loads).Should(Equal(int32(1)))
+	})
+
+	It("should cache missing values", func() {
+		var r rate
+		Expect(c.Get(ctx, "XXX", &r, loader(nil, dynamo.ErrNotFound))).Should(Equal(dynamo.ErrNotFound))
+		Expect(*fake.lastPut.Item["missing"].BOOL).Should(BeTrue())
+
+		Expect(c.Get(ctx, "XXX", &r, loader(rate{}, nil))).Should(Equal(dynamo.ErrNotFound))
+		Expect(newCache().Get(ctx, "XXX", &r, loader(rate{}, nil))).Should(Equal(dynamo.ErrNotFound))
+		Expect(loads).Should(Equal(int32(1)))
+	})
+
+	It("should not cache loader errors", func() {
+		boom := errors.New("boom")
+		var r rate
+		Expect(c.Get(ctx, "EUR", &r, loader(nil, boom))).Should(Equal(boom))
+		Expect(c.Get(ctx, "EUR", &r, loader(rate{"EUR", 1}, nil))).Should(BeNil())
+		Expect(loads).Should(Equal(int32(2)))
+	})
+
+	It("should reload expired table entries", func() {
+		fake.store(item{
+			"id":      {S: aws.String("EUR")},
+			"value":   {M: item{"currency": {S: aws.String("EUR")}, "value": {N: aws.String("0.9")}}},
+			"expires": {N: aws.String("1")},
+		})
+
+		var r rate
+		Expect(c.Get(ctx, "EUR", &r, loader(rate{"EUR", 1.2}, nil))).Should(BeNil())
+		Expect(r.Value).Should(Equal(1.2))
+	})
+
+	It("should share concurrent loads", func() {
+		release := make(chan struct{})
+		slow := func(context.Context) (interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return rate{"EUR", 1}, nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				var r rate
+				Expect(c.Get(ctx, "EUR", &r, slow)).Should(BeNil())
+				Expect(r.Value).Should(Equal(1.0))
+			}()
+		}
+
+		Eventually(func() int32 { return atomic.LoadInt32(&loads) }).Should(Equal(int32(1)))
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		Expect(loads).Should(Equal(int32(1)))
+	})
+
+	It("should serve loaded values when they cannot be stored", func() {
+		fake.setFailConditions(1)
+		var r rate
+		Expect(c.Get(ctx, "EUR", &r, loader(rate{"EUR", 1}, nil))).Should(BeNil())
+		Expect(r.Value).Should(Equal(1.0))
+	})
+
+	It("should invalidate entries", func() {
+		var r rate
+		Expect(c.Get(ctx, "EUR", &r, loader(rate{"EUR", 1}, nil))).Should(BeNil())
+		Expect(c.Invalidate(ctx, "EUR")).Should(BeNil())
+		Expect(fake.items).Should(BeEmpty())
+
+		Expect(c.Get(ctx, "EUR", &r, loader(rate{"EUR", 2}, nil))).Should(BeNil())
+		Expect(r.Value).Should(Equal(2.0))
+	})
+
+	It("should bound the entries kept in memory", func() {
+		c.Size = 1
+		var r rate
+		Expect(c.Get(ctx, "EUR", &r, loader(rate{"EUR", 1}, nil))).Should(BeNil())
+		Expect(c.Get(ctx, "USD", &r, loader(rate{"USD", 1}, nil))).Should(BeNil())
+		Expect(c.Get(ctx, "EUR", &r, loader(nil, nil))).Should(BeNil())
+		Expect(fake.count("GetItem")).Should(Equal(3))
+	})
+
+	It("should use the configured partition key", func() {
+		c.PartitionKey = "pk"
+		var r rate
+		Expect(c.Get(ctx, "EUR", &r, loader(rate{"EUR", 1}, nil))).Should(BeNil())
+		Expect(fake.lastPut.Item).Should(HaveKey("pk"))
+		Expect(fake.lastPut.Item["missing"]).Should(BeNil())
+	})
+})
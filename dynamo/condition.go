@@ -0,0 +1,180 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+var (
+	// ErrConditionFailed is returned when the condition of a write is not met
+	ErrConditionFailed = errors.New("dynamo: condition failed")
+
+	// ErrVersionConflict is returned when a versioned item was changed by another writer
+	ErrVersionConflict = errors.New("dynamo: version conflict")
+
+	// ErrItemExists is returned by PutIfNotExists when an item with the same key exists
+	ErrItemExists = errors.New("dynamo: item already exists")
+)
+
+// ConditionError describes a conditional write rejected by DynamoDB. It matches
+// ErrConditionFailed and, for writes guarded by a version attribute, ErrVersionConflict, or
+// ErrItemExists for a key clash of PutIfNotExists.
+type ConditionError struct {
+	Table   string
+	Key     map[string]*dynamodb.AttributeValue
+	Version int64
+	Err     error
+
+	versioned bool
+	exists    bool
+}
+
+func (e *ConditionError) Error() string {
+	switch {
+	case e.exists:
+		return fmt.Sprintf("%s: table %s key %v", ErrItemExists, e.Table, e.Key)
+	case e.versioned:
+		return fmt.Sprintf("%s: table %s key %v expected version %d", ErrVersionConflict, e.Table, e.Key, e.Version)
+	}
+
+	return fmt.Sprintf("%s: table %s key %v", ErrConditionFailed, e.Table, e.Key)
+}
+
+// Is reports whether the error matches ErrConditionFailed, ErrVersionConflict or ErrItemExists
+func (e *ConditionError) Is(target error) bool {
+	return target == ErrConditionFailed ||
+		(e.versioned && target == ErrVersionConflict) ||
+		(e.exists && target == ErrItemExists)
+}
+
+// Unwrap returns the underlying AWS error
+func (e *ConditionError) Unwrap() error {
+	return e.Err
+}
+
+// IsConditionalCheckFailed reports whether err is a DynamoDB ConditionalCheckFailedException
+func IsConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// versionLock tracks the optimistic locking state of a single write
+type versionLock struct {
+	field *Field
+	value reflect.Value
+	old   int64
+}
+
+// lockVersion reads the version of the item and, when bump is set, increments it in place
+func (t *Table) lockVersion(v reflect.Value, bump bool) (*versionLock, error) {
+	f := t.Schema.Version
+	if f == nil {
+		return nil, nil
+	}
+
+	fv := f.value(v)
+	if bump && !fv.CanSet() {
+		return nil, fmt.Errorf("dynamo: versioned %s must be written through a pointer", v.Type())
+	}
+
+	l := &versionLock{field: f, value: fv}
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		l.old = fv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		l.old = int64(fv.Uint())
+	default:
+		return nil, fmt.Errorf("dynamo: version field %s must be an integer", f.Name)
+	}
+
+	if bump {
+		l.set(l.old + 1)
+	}
+
+	return l, nil
+}
+
+func (l *versionLock) set(n int64) {
+	if l.value.Kind() >= reflect.Uint && l.value.Kind() <= reflect.Uint64 {
+		l.value.SetUint(uint64(n))
+	} else {
+		l.value.SetInt(n)
+	}
+}
+
+// rollback restores the version of the item after a failed write
+func (l *versionLock) rollback() {
+	if l != nil && l.value.CanSet() {
+		l.set(l.old)
+	}
+}
+
+// condition returns the expression asserting the stored version is the one read
+func (l *versionLock) condition() *expression.ConditionBuilder {
+	if l == nil {
+		return nil
+	}
+
+	name := expression.Name(l.field.Attribute)
+	cond := name.Equal(expression.Value(l.old))
+	if l.old == 0 {
+		cond = name.AttributeNotExists()
+	}
+
+	return &cond
+}
+
+func combine(conds ...*expression.ConditionBuilder) *expression.ConditionBuilder {
+	var out *expression.ConditionBuilder
+	for _, c := range conds {
+		switch {
+		case c == nil:
+		case out == nil:
+			cc := *c
+			out = &cc
+		default:
+			cc := out.And(*c)
+			out = &cc
+		}
+	}
+
+	return out
+}
+
+func (t *Table) conditionError(err error, w *write) error {
+	if !IsConditionalCheckFailed(err) {
+		return err
+	}
+
+	ce := &ConditionError{Table: t.Name, Key: w.key, Err: err}
+	switch {
+	case w.notExists:
+		ce.exists = true
+	case w.lock != nil:
+		ce.versioned = true
+		ce.Version = w.lock.old
+	}
+
+	return ce
+}
+
+// PutIfNotExists writes the item only when no item with the same key exists yet. The version
+// of a versioned item is set without being checked, as a new item has none. A clash is
+// reported as ErrItemExists, unless conditions were added with If, whose failures cannot be
+// told apart from it and match ErrConditionFailed only.
+func (t *Table) PutIfNotExists(ctx aws.Context, item interface{}, opts ...Option) error {
+	return t.Put(ctx, item, append(opts, ifNotExists())...)
+}
+
+// DeleteIfMatches deletes the item only when the stored item satisfies cond. A mismatch is
+// reported as ErrConditionFailed.
+func (t *Table) DeleteIfMatches(ctx aws.Context, item interface{}, cond expression.ConditionBuilder, opts ...Option) error {
+	return t.Delete(ctx, item, append(opts, If(cond))...)
+}
@@ -0,0 +1,127 @@
+package dynamo
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	counterCountAttribute   = "count"
+	counterExpiresAttribute = "expires"
+)
+
+// Counters keeps named atomic counters in a table with a string partition key. Windowed
+// counters are stored per window and carry an "expires" attribute for DynamoDB TTL.
+type Counters struct {
+	Table string
+
+	// PartitionKey is the string key attribute of the table, "id" by default
+	PartitionKey string
+
+	// Client defaults to services.DynamoDbClient() when nil
+	Client dynamodbiface.DynamoDBAPI
+}
+
+// NewCounters creates a counter client on the given table
+func NewCounters(table string) *Counters {
+	return &Counters{Table: table, PartitionKey: "id"}
+}
+
+func (c *Counters) key(name string) map[string]*dynamodb.AttributeValue {
+	pk := c.PartitionKey
+	if pk == "" {
+		pk = "id"
+	}
+
+	return map[string]*dynamodb.AttributeValue{pk: {S: aws.String(name)}}
+}
+
+// Increment atomically adds delta (which may be negative) to the counter and returns the new
+// value. Missing counters start at zero.
+func (c *Counters) Increment(ctx aws.Context, name string, delta int64) (int64, error) {
+	return c.add(ctx, name, delta, nil)
+}
+
+// Get returns the current value of the counter, zero when it does not exist
+func (c *Counters) Get(ctx aws.Context, name string) (int64, error) {
+	out, err := clientOrDefault(c.Client).GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(c.Table),
+		Key:            c.key(name),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return numberAttribute(out.Item, counterCountAttribute)
+}
+
+// Reset deletes the counter
+func (c *Counters) Reset(ctx aws.Context, name string) error {
+	_, err := clientOrDefault(c.Client).DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(c.Table),
+		Key:       c.key(name),
+	})
+
+	return err
+}
+
+// IncrementWindow adds delta to the counter of the current fixed window (e.g. the current
+// minute for time.Minute) and returns its new value. Window counters expire one window after
+// they close.
+func (c *Counters) IncrementWindow(ctx aws.Context, name string, window time.Duration, delta int64) (int64, error) {
+	start := time.Now().Truncate(window)
+	expires := start.Add(2 * window).Unix()
+
+	return c.add(ctx, windowName(name, start), delta, &expires)
+}
+
+// GetWindow returns the value of the counter of the current window
+func (c *Counters) GetWindow(ctx aws.Context, name string, window time.Duration) (int64, error) {
+	return c.Get(ctx, windowName(name, time.Now().Truncate(window)))
+}
+
+func windowName(name string, start time.Time) string {
+	return name + "#" + strconv.FormatInt(start.Unix(), 10)
+}
+
+func (c *Counters) add(ctx aws.Context, name string, delta int64, expires *int64) (int64, error) {
+	update := expression.Add(expression.Name(counterCountAttribute), expression.Value(delta))
+	if expires != nil {
+		update = update.Set(expression.Name(counterExpiresAttribute),
+			expression.IfNotExists(expression.Name(counterExpiresAttribute), expression.Value(*expires)))
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := clientOrDefault(c.Client).UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.Table),
+		Key:                       c.key(name),
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedNew),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return numberAttribute(out.Attributes, counterCountAttribute)
+}
+
+func numberAttribute(item map[string]*dynamodb.AttributeValue, name string) (int64, error) {
+	av, ok := item[name]
+	if !ok || av.N == nil {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(*av.N, 10, 64)
+}
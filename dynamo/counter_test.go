@@ -0,0 +1,138 @@
+package dynamo_test
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// updatingDynamo applies the SET and ADD clauses of update expressions built by the
+// expression package (plain values and if_not_exists only) and checks equality and
+// attribute_not_exists conditions
+type updatingDynamo struct {
+	*fakeDynamo
+}
+
+var (
+	assignment = regexp.MustCompile(`^(#\d+) = (?:if_not_exists\((#\d+), (:\d+)\)|(:\d+))$`)
+	equality   = regexp.MustCompile(`^(#\d+) = (:\d+)$`)
+	notExists  = regexp.MustCompile(`^attribute_not_exists \((#\d+)\)$`)
+)
+
+func (f updatingDynamo) holds(in *dynamodb.UpdateItemInput, current item) bool {
+	if in.ConditionExpression == nil {
+		return true
+	}
+
+	cond := *in.ConditionExpression
+	if m := equality.FindStringSubmatch(cond); m != nil {
+		v, ok := current[*in.ExpressionAttributeNames[m[1]]]
+		return ok && v.String() == in.ExpressionAttributeValues[m[2]].String()
+	}
+	m := notExists.FindStringSubmatch(cond)
+	Expect(m).ShouldNot(BeNil(), cond)
+	_, ok := current[*in.ExpressionAttributeNames[m[1]]]
+
+	return !ok
+}
+
+func (f updatingDynamo) UpdateItemWithContext(_ aws.Context, in *dynamodb.UpdateItemInput, _ ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["UpdateItem"]++
+	f.lastUpdate = in
+
+	if err := f.conditionFailed(); err != nil {
+		return nil, err
+	}
+
+	current := item{}
+	for k, v := range f.items[f.keyString(in.Key)] {
+		current[k] = v
+	}
+	if !f.holds(in, current) {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+	for k, v := range in.Key {
+		current[k] = v
+	}
+
+	for _, clause := range strings.Split(strings.TrimSpace(*in.UpdateExpression), "\n") {
+		verb, body := clause[:strings.Index(clause, " ")], clause[strings.Index(clause, " ")+1:]
+		for i, part := range strings.Split(body, ", #") {
+			if i > 0 {
+				part = "#" + part
+			}
+			switch verb {
+			case "ADD":
+				fields := strings.Fields(part)
+				name, delta := *in.ExpressionAttributeNames[fields[0]], in.ExpressionAttributeValues[fields[1]]
+				n, _ := strconv.ParseInt(aws.StringValue(delta.N), 10, 64)
+				if old, ok := current[name]; ok {
+					o, _ := strconv.ParseInt(*old.N, 10, 64)
+					n += o
+				}
+				current[name] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+			case "SET":
+				m := assignment.FindStringSubmatch(part)
+				Expect(m).ShouldNot(BeNil(), part)
+				name := *in.ExpressionAttributeNames[m[1]]
+				if m[4] != "" {
+					current[name] = in.ExpressionAttributeValues[m[4]]
+				} else if _, ok := current[name]; !ok {
+					current[name] = in.ExpressionAttributeValues[m[3]]
+				}
+			}
+		}
+	}
+	f.store(current)
+
+	return &dynamodb.UpdateItemOutput{Attributes: current}, nil
+}
+
+var _ = Describe("Counters", func() {
+	var (
+		ctx      context.Context
+		fake     *fakeDynamo
+		counters *dynamo.Counters
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeDynamo("id")
+		counters = dynamo.NewCounters("counters")
+		counters.Client = updatingDynamo{fake}
+	})
+
+	It("should increment, read and reset counters", func() {
+		Expect(counters.Get(ctx, "calls")).Should(Equal(int64(0)))
+		Expect(counters.Increment(ctx, "calls", 2)).Should(Equal(int64(2)))
+		Expect(counters.Increment(ctx, "calls", -1)).Should(Equal(int64(1)))
+		Expect(counters.Get(ctx, "calls")).Should(Equal(int64(1)))
+		Expect(*fake.lastUpdate.ReturnValues).Should(Equal(dynamodb.ReturnValueUpdatedNew))
+
+		Expect(counters.Reset(ctx, "calls")).Should(BeNil())
+		Expect(counters.Get(ctx, "calls")).Should(Equal(int64(0)))
+	})
+
+	It("should count per window with an expiry", func() {
+		Expect(counters.IncrementWindow(ctx, "calls", time.Hour, 1)).Should(Equal(int64(1)))
+		Expect(counters.IncrementWindow(ctx, "calls", time.Hour, 1)).Should(Equal(int64(2)))
+		Expect(counters.GetWindow(ctx, "calls", time.Hour)).Should(Equal(int64(2)))
+		Expect(counters.Get(ctx, "calls")).Should(Equal(int64(0)))
+
+		key := *fake.lastUpdate.Key["id"].S
+		Expect(key).Should(HavePrefix("calls#"))
+		expires, _ := strconv.ParseInt(*fake.items[fake.keyString(fake.lastUpdate.Key)]["expires"].N, 10, 64)
+		Expect(expires).Should(BeNumerically(">", time.Now().Unix()))
+	})
+})
@@ -0,0 +1,224 @@
+package dynamo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kraneware/kws/services"
+)
+
+// ChangeType tells how an attribute changed between two images
+type ChangeType string
+
+// Change types
+const (
+	Added    ChangeType = "ADDED"
+	Removed  ChangeType = "REMOVED"
+	Modified ChangeType = "MODIFIED"
+)
+
+// Change is a single attribute difference. Path addresses the attribute with dots for map keys
+// and brackets for list indexes, e.g. address.lines[1]. Old is the NULL zero value for an
+// added attribute and New for a removed one.
+type Change struct {
+	Path string
+	Type ChangeType
+	Old  events.DynamoDBAttributeValue
+	New  events.DynamoDBAttributeValue
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s", c.Type, c.Path)
+}
+
+// ChangeSet is the list of changes between two images, sorted by path
+type ChangeSet []Change
+
+// Paths returns the path of every change
+func (cs ChangeSet) Paths() []string {
+	out := make([]string, len(cs))
+	for i, c := range cs {
+		out[i] = c.Path
+	}
+
+	return out
+}
+
+// Touches reports whether any change affects one of the given paths: the path itself, an
+// attribute nested below it or a map or list containing it
+func (cs ChangeSet) Touches(paths ...string) bool {
+	for _, c := range cs {
+		for _, p := range paths {
+			if within(c.Path, p) || within(p, c.Path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// within reports whether path equals parent or is nested below it
+func within(path, parent string) bool {
+	if !strings.HasPrefix(path, parent) {
+		return false
+	}
+
+	rest := path[len(parent):]
+
+	return rest == "" || rest[0] == '.' || rest[0] == '['
+}
+
+// Diff compares two stream images, descending into maps and lists. Sets are compared as a
+// whole, regardless of element order.
+func Diff(old, new map[string]events.DynamoDBAttributeValue) ChangeSet {
+	var cs ChangeSet
+	diffMaps(&cs, "", old, new)
+
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].Path < cs[j].Path })
+
+	return cs
+}
+
+// DiffRecord compares the OldImage and NewImage of a stream record. The stream must use the
+// NEW_AND_OLD_IMAGES view type for MODIFY records to produce meaningful changes.
+func DiffRecord(rec events.DynamoDBEventRecord) ChangeSet {
+	return Diff(rec.Change.OldImage, rec.Change.NewImage)
+}
+
+// DiffStructs compares two values of a model as they would be stored in DynamoDB. Either may
+// be nil.
+func DiffStructs(old, new interface{}) (ChangeSet, error) {
+	var (
+		oldImage, newImage map[string]events.DynamoDBAttributeValue
+		err                error
+	)
+
+	if old != nil {
+		oldImage, err = services.MarshalStreamImage(old)
+	}
+	if err == nil && new != nil {
+		newImage, err = services.MarshalStreamImage(new)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return Diff(oldImage, newImage), nil
+}
+
+func diffMaps(cs *ChangeSet, prefix string, old, new map[string]events.DynamoDBAttributeValue) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+
+	for k, o := range old {
+		if n, ok := new[k]; ok {
+			diffValues(cs, join(k), o, n)
+		} else {
+			*cs = append(*cs, Change{Path: join(k), Type: Removed, Old: o, New: events.NewNullAttribute()})
+		}
+	}
+
+	for k, n := range new {
+		if _, ok := old[k]; !ok {
+			*cs = append(*cs, Change{Path: join(k), Type: Added, Old: events.NewNullAttribute(), New: n})
+		}
+	}
+}
+
+func diffValues(cs *ChangeSet, path string, old, new events.DynamoDBAttributeValue) {
+	if !old.IsNull() && !new.IsNull() && old.DataType() == new.DataType() {
+		switch old.DataType() {
+		case events.DataTypeMap:
+			diffMaps(cs, path, old.Map(), new.Map())
+			return
+		case events.DataTypeList:
+			diffLists(cs, path, old.List(), new.List())
+			return
+		}
+	}
+
+	if !equalValues(old, new) {
+		*cs = append(*cs, Change{Path: path, Type: Modified, Old: old, New: new})
+	}
+}
+
+func diffLists(cs *ChangeSet, path string, old, new []events.DynamoDBAttributeValue) {
+	for i := 0; i < len(old) || i < len(new); i++ {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(new):
+			*cs = append(*cs, Change{Path: p, Type: Removed, Old: old[i], New: events.NewNullAttribute()})
+		case i >= len(old):
+			*cs = append(*cs, Change{Path: p, Type: Added, Old: events.NewNullAttribute(), New: new[i]})
+		default:
+			diffValues(cs, p, old[i], new[i])
+		}
+	}
+}
+
+func equalValues(a, b events.DynamoDBAttributeValue) bool {
+	if a.IsNull() || b.IsNull() {
+		return a.IsNull() && b.IsNull()
+	}
+	if a.DataType() != b.DataType() {
+		return false
+	}
+
+	switch a.DataType() {
+	case events.DataTypeString:
+		return a.String() == b.String()
+	case events.DataTypeNumber:
+		return a.Number() == b.Number()
+	case events.DataTypeBoolean:
+		return a.Boolean() == b.Boolean()
+	case events.DataTypeBinary:
+		return bytes.Equal(a.Binary(), b.Binary())
+	case events.DataTypeStringSet:
+		return equalSets(a.StringSet(), b.StringSet())
+	case events.DataTypeNumberSet:
+		return equalSets(a.NumberSet(), b.NumberSet())
+	case events.DataTypeBinarySet:
+		return equalSets(binaryStrings(a.BinarySet()), binaryStrings(b.BinarySet()))
+	}
+
+	var cs ChangeSet
+	diffValues(&cs, "", a, b)
+
+	return len(cs) == 0
+}
+
+func binaryStrings(bs [][]byte) []string {
+	out := make([]string, len(bs))
+	for i, b := range bs {
+		out[i] = string(b)
+	}
+
+	return out
+}
+
+func equalSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	seen := make(map[string]int, len(a))
+	for _, s := range a {
+		seen[s]++
+	}
+	for _, s := range b {
+		if seen[s] == 0 {
+			return false
+		}
+		seen[s]--
+	}
+
+	return true
+}
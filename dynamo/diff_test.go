@@ -0,0 +1,101 @@
+package dynamo_test
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type address struct {
+	City  string   `json:"city"`
+	Lines []string `json:"lines"`
+}
+
+type profile struct {
+	ID      string            `json:"id" dynamo:"pk"`
+	Name    string            `json:"name"`
+	Email   string            `json:"email,omitempty"`
+	Address address           `json:"address"`
+	Tags    []string          `json:"tags" dynamodbav:"tags,stringset"`
+	Meta    map[string]string `json:"meta"`
+}
+
+var _ = Describe("Diff", func() {
+	old := profile{
+		ID:      "p1",
+		Name:    "Ann",
+		Email:   "ann@example.com",
+		Address: address{City: "Oslo", Lines: []string{"a", "b"}},
+		Tags:    []string{"x", "y"},
+		Meta:    map[string]string{"k": "v"},
+	}
+
+	It("should report nested changes by path", func() {
+		updated := old
+		updated.Name = "Anna"
+		updated.Email = ""
+		updated.Address = address{City: "Oslo", Lines: []string{"a", "c", "d"}}
+		updated.Tags = []string{"y", "x"}
+		updated.Meta = map[string]string{"k": "v", "n": "1"}
+
+		cs, err := dynamo.DiffStructs(old, updated)
+		Expect(err).Should(BeNil())
+		Expect(cs.Paths()).Should(Equal([]string{"address.lines[1]", "address.lines[2]", "email", "meta.n", "name"}))
+
+		Expect(cs[0].Type).Should(Equal(dynamo.Modified))
+		Expect(cs[0].Old.String()).Should(Equal("b"))
+		Expect(cs[0].New.String()).Should(Equal("c"))
+		Expect(cs[1].Type).Should(Equal(dynamo.Added))
+		Expect(cs[2].Type).Should(Equal(dynamo.Removed))
+		Expect(cs[2].New.IsNull()).Should(BeTrue())
+
+		Expect(cs.Touches("address")).Should(BeTrue())
+		Expect(cs.Touches("address.lines[2]")).Should(BeTrue())
+		Expect(cs.Touches("address.city", "tags")).Should(BeFalse())
+		Expect(cs.Touches("nam")).Should(BeFalse())
+	})
+
+	It("should treat inserts and type changes as whole values", func() {
+		cs, err := dynamo.DiffStructs(nil, old)
+		Expect(err).Should(BeNil())
+		Expect(cs).Should(HaveLen(6))
+		Expect(cs[0].Type).Should(Equal(dynamo.Added))
+
+		cs = dynamo.Diff(
+			map[string]events.DynamoDBAttributeValue{"a": events.NewStringAttribute("1")},
+			map[string]events.DynamoDBAttributeValue{"a": events.NewNumberAttribute("1")},
+		)
+		Expect(cs).Should(HaveLen(1))
+		Expect(cs[0].Type).Should(Equal(dynamo.Modified))
+
+		Expect(dynamo.DiffStructs(old, old)).Should(BeEmpty())
+	})
+
+	It("should only call path subscribers when their paths change", func() {
+		d := dynamo.NewStreamDispatcher()
+		var calls []dynamo.ChangeSet
+		Expect(d.HandleChanges("orders", order{}, []string{"status"}, func(_ context.Context, r *dynamo.StreamRecord) error {
+			calls = append(calls, r.Changes())
+			return nil
+		}, dynamo.EventModify)).Should(BeNil())
+		Expect(d.HandleChanges("orders", order{}, nil, nil)).ShouldNot(BeNil())
+
+		o := order{Customer: "c1", ID: "o1", Status: "NEW"}
+		more := o
+		more.Total = 5
+		paid := more
+		paid.Status = "PAID"
+
+		resp, err := d.Dispatch(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+			streamRecord("1", dynamo.EventModify, o, more),
+			streamRecord("2", dynamo.EventModify, more, paid),
+		}})
+		Expect(err).Should(BeNil())
+		Expect(resp.BatchItemFailures).Should(BeEmpty())
+		Expect(calls).Should(HaveLen(1))
+		Expect(calls[0].Paths()).Should(Equal([]string{"status"}))
+	})
+})
@@ -0,0 +1,345 @@
+package dynamo_test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestDynamo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DynamoDB Helpers Test Suite")
+}
+
+type item = map[string]*dynamodb.AttributeValue
+
+// fakeDynamo is a minimal in-memory table keyed on the given attributes.  It does not evaluate
+// expressions; conditional failures are injected with failConditions.
+type fakeDynamo struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu             sync.Mutex
+	keys           []string
+	order          []string
+	items          map[string]item
+	pageSize       int
+	failConditions int
+	unprocessed    int
+	calls          map[string]int
+	lastPut        *dynamodb.PutItemInput
+	lastUpdate     *dynamodb.UpdateItemInput
+	lastDelete     *dynamodb.DeleteItemInput
+	lastQuery      *dynamodb.QueryInput
+	lastTx         *dynamodb.TransactWriteItemsInput
+	cancelReasons  []*dynamodb.CancellationReason
+}
+
+func newFakeDynamo(keys ...string) *fakeDynamo {
+	return &fakeDynamo{
+		keys:  keys,
+		items: make(map[string]item),
+		calls: make(map[string]int),
+	}
+}
+
+func (f *fakeDynamo) keyString(av item) string {
+	parts := make([]string, 0, len(f.keys))
+	for _, k := range f.keys {
+		if v, ok := av[k]; ok {
+			parts = append(parts, v.String())
+		}
+	}
+
+	return strings.Join(parts, "|")
+}
+
+func (f *fakeDynamo) store(av item) {
+	k := f.keyString(av)
+	if _, ok := f.items[k]; !ok {
+		f.order = append(f.order, k)
+	}
+	f.items[k] = av
+}
+
+func (f *fakeDynamo) count(call string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[call]
+}
+
+func (f *fakeDynamo) setFailConditions(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failConditions = n
+}
+
+func (f *fakeDynamo) conditionFailed() error {
+	if f.failConditions > 0 {
+		f.failConditions--
+		return &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+
+	return nil
+}
+
+func (f *fakeDynamo) all() []item {
+	out := make([]item, 0, len(f.order))
+	for _, k := range f.order {
+		if v, ok := f.items[k]; ok {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+func (f *fakeDynamo) page(all []item, start item, limit *int64) ([]item, item) {
+	sort.SliceStable(all, func(i, j int) bool { return f.keyString(all[i]) < f.keyString(all[j]) })
+
+	from := 0
+	if start != nil {
+		s := f.keyString(start)
+		for from < len(all) && f.keyString(all[from]) <= s {
+			from++
+		}
+	}
+
+	size := len(all) - from
+	if f.pageSize > 0 && f.pageSize < size {
+		size = f.pageSize
+	}
+	if limit != nil && int(*limit) < size {
+		size = int(*limit)
+	}
+
+	items := all[from : from+size]
+	if from+size < len(all) && size > 0 {
+		return items, items[size-1]
+	}
+
+	return items, nil
+}
+
+func (f *fakeDynamo) DescribeTableWithContext(_ aws.Context, in *dynamodb.DescribeTableInput, _ ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["DescribeTable"]++
+
+	schema := make([]*dynamodb.KeySchemaElement, len(f.keys))
+	for i, k := range f.keys {
+		schema[i] = &dynamodb.KeySchemaElement{AttributeName: aws.String(k), KeyType: aws.String(dynamodb.KeyTypeHash)}
+		if i > 0 {
+			schema[i].KeyType = aws.String(dynamodb.KeyTypeRange)
+		}
+	}
+
+	return &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{TableName: in.TableName, KeySchema: schema}}, nil
+}
+
+func (f *fakeDynamo) GetItemWithContext(_ aws.Context, in *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetItem"]++
+
+	return &dynamodb.GetItemOutput{Item: f.items[f.keyString(in.Key)]}, nil
+}
+
+func (f *fakeDynamo) PutItemWithContext(_ aws.Context, in *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["PutItem"]++
+	f.lastPut = in
+
+	if err := f.conditionFailed(); err != nil {
+		return nil, err
+	}
+	f.store(in.Item)
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamo) DeleteItemWithContext(_ aws.Context, in *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["DeleteItem"]++
+	f.lastDelete = in
+
+	if err := f.conditionFailed(); err != nil {
+		return nil, err
+	}
+	delete(f.items, f.keyString(in.Key))
+
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeDynamo) UpdateItemWithContext(_ aws.Context, in *dynamodb.UpdateItemInput, _ ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["UpdateItem"]++
+	f.lastUpdate = in
+
+	if err := f.conditionFailed(); err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.UpdateItemOutput{Attributes: f.items[f.keyString(in.Key)]}, nil
+}
+
+func (f *fakeDynamo) QueryWithContext(_ aws.Context, in *dynamodb.QueryInput, _ ...request.Option) (*dynamodb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["Query"]++
+	f.lastQuery = in
+
+	items, last := f.page(f.all(), in.ExclusiveStartKey, in.Limit)
+
+	return &dynamodb.QueryOutput{Items: items, LastEvaluatedKey: last}, nil
+}
+
+func (f *fakeDynamo) ScanWithContext(_ aws.Context, in *dynamodb.ScanInput, _ ...request.Option) (*dynamodb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["Scan"]++
+
+	all := f.all()
+	if in.TotalSegments != nil {
+		var segment []item
+		for i, v := range all {
+			if int64(i)%*in.TotalSegments == *in.Segment {
+				segment = append(segment, v)
+			}
+		}
+		all = segment
+	}
+
+	items, last := f.page(all, in.ExclusiveStartKey, in.Limit)
+
+	return &dynamodb.ScanOutput{Items: items, LastEvaluatedKey: last}, nil
+}
+
+// takeUnprocessed splits off the last request of a batch while unprocessed is positive
+func (f *fakeDynamo) takeUnprocessed(n int) int {
+	if f.unprocessed > 0 && n > 0 {
+		f.unprocessed--
+		return n - 1
+	}
+
+	return n
+}
+
+func (f *fakeDynamo) BatchWriteItemWithContext(_ aws.Context, in *dynamodb.BatchWriteItemInput, _ ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["BatchWriteItem"]++
+
+	out := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+	for table, reqs := range in.RequestItems {
+		if len(reqs) > 25 {
+			return nil, fmt.Errorf("too many items: %d", len(reqs))
+		}
+
+		seen := make(map[string]bool)
+		for _, r := range reqs {
+			k := ""
+			if r.PutRequest != nil {
+				k = f.keyString(r.PutRequest.Item)
+			} else {
+				k = f.keyString(r.DeleteRequest.Key)
+			}
+			if seen[k] {
+				return nil, awserr.New("ValidationException", "Provided list of item keys contains duplicates", nil)
+			}
+			seen[k] = true
+		}
+
+		n := f.takeUnprocessed(len(reqs))
+		for _, r := range reqs[:n] {
+			if r.PutRequest != nil {
+				f.store(r.PutRequest.Item)
+			} else {
+				delete(f.items, f.keyString(r.DeleteRequest.Key))
+			}
+		}
+		if n < len(reqs) {
+			out.UnprocessedItems[table] = reqs[n:]
+		}
+	}
+
+	return out, nil
+}
+
+func (f *fakeDynamo) BatchGetItemWithContext(_ aws.Context, in *dynamodb.BatchGetItemInput, _ ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["BatchGetItem"]++
+
+	out := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]item{},
+		UnprocessedKeys: map[string]*dynamodb.KeysAndAttributes{},
+	}
+	for table, ka := range in.RequestItems {
+		if len(ka.Keys) > 100 {
+			return nil, fmt.Errorf("too many keys: %d", len(ka.Keys))
+		}
+
+		n := f.takeUnprocessed(len(ka.Keys))
+		for _, k := range ka.Keys[:n] {
+			if v, ok := f.items[f.keyString(k)]; ok {
+				out.Responses[table] = append(out.Responses[table], v)
+			}
+		}
+		if n < len(ka.Keys) {
+			out.UnprocessedKeys[table] = &dynamodb.KeysAndAttributes{Keys: ka.Keys[n:]}
+		}
+	}
+
+	return out, nil
+}
+
+func (f *fakeDynamo) TransactWriteItemsWithContext(_ aws.Context, in *dynamodb.TransactWriteItemsInput, _ ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["TransactWriteItems"]++
+	f.lastTx = in
+
+	if f.cancelReasons != nil {
+		return nil, &dynamodb.TransactionCanceledException{
+			Message_:            aws.String("Transaction cancelled"),
+			CancellationReasons: f.cancelReasons,
+		}
+	}
+
+	for _, ti := range in.TransactItems {
+		if ti.Put != nil {
+			f.store(ti.Put.Item)
+		} else if ti.Delete != nil {
+			delete(f.items, f.keyString(ti.Delete.Key))
+		}
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (f *fakeDynamo) TransactGetItemsWithContext(_ aws.Context, in *dynamodb.TransactGetItemsInput, _ ...request.Option) (*dynamodb.TransactGetItemsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["TransactGetItems"]++
+
+	out := &dynamodb.TransactGetItemsOutput{}
+	for _, ti := range in.TransactItems {
+		out.Responses = append(out.Responses, &dynamodb.ItemResponse{Item: f.items[f.keyString(ti.Get.Key)]})
+	}
+
+	return out, nil
+}
@@ -0,0 +1,216 @@
+// Package dynamotest builds DynamoDB stream events from tagged models for unit tests of stream
+// consumers.
+//
+// Example:
+//
+//	event := dynamotest.NewStream("orders").
+//		Insert(order).
+//		Modify(order, paid).
+//		Remove(paid).
+//		Event()
+//	resp, err := dispatcher.Dispatch(ctx, event)
+package dynamotest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/dynamo"
+	"github.com/kraneware/kws/services"
+)
+
+const (
+	// DefaultRegion is the region used in generated ARNs
+	DefaultRegion = "us-east-1"
+
+	// DefaultAccount is the account id used in generated ARNs
+	DefaultAccount = "123456789012"
+)
+
+// Stream accumulates the records of a single table stream. Sequence numbers and approximate
+// creation times increase with every record. Builder errors (e.g. a model without a pk tag)
+// are reported by Build, and make Event panic.
+type Stream struct {
+	Table    string
+	Region   string
+	Account  string
+	ViewType string
+	Start    time.Time
+
+	seq     int64
+	records []events.DynamoDBEventRecord
+	err     error
+}
+
+// NewStream starts a NEW_AND_OLD_IMAGES stream for the given table
+func NewStream(table string) *Stream {
+	return &Stream{
+		Table:    table,
+		Region:   DefaultRegion,
+		Account:  DefaultAccount,
+		ViewType: dynamodb.StreamViewTypeNewAndOldImages,
+		Start:    time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// WithViewType changes the StreamViewType; images the view type does not carry are left out
+// of the following records
+func (s *Stream) WithViewType(viewType string) *Stream {
+	s.ViewType = viewType
+	return s
+}
+
+// ARN returns the event source ARN of the stream
+func (s *Stream) ARN() string {
+	return fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/%s/stream/%s",
+		s.Region, s.Account, s.Table, s.Start.Format("2006-01-02T15:04:05.000"))
+}
+
+// Insert adds an INSERT record of item
+func (s *Stream) Insert(item interface{}) *Stream {
+	return s.add(dynamo.EventInsert, nil, item)
+}
+
+// Modify adds a MODIFY record changing old into new. Keys are taken from new.
+func (s *Stream) Modify(old, new interface{}) *Stream {
+	return s.add(dynamo.EventModify, old, new)
+}
+
+// Remove adds a REMOVE record of item
+func (s *Stream) Remove(item interface{}) *Stream {
+	return s.add(dynamo.EventRemove, item, nil)
+}
+
+func (s *Stream) add(name string, old, new interface{}) *Stream {
+	if s.err != nil {
+		return s
+	}
+
+	keySource := new
+	if keySource == nil {
+		keySource = old
+	}
+
+	var (
+		oldImage, newImage, keys map[string]events.DynamoDBAttributeValue
+		err                      error
+	)
+	keys, err = Keys(keySource)
+	if err == nil && old != nil && s.carries(dynamodb.StreamViewTypeOldImage) {
+		oldImage, err = services.MarshalStreamImage(old)
+	}
+	if err == nil && new != nil && s.carries(dynamodb.StreamViewTypeNewImage) {
+		newImage, err = services.MarshalStreamImage(new)
+	}
+	if err != nil {
+		s.err = err
+		return s
+	}
+
+	s.seq++
+	seq := fmt.Sprintf("1%020d", s.seq*100)
+	id := sha256.Sum256([]byte(s.ARN() + seq))
+
+	change := events.DynamoDBStreamRecord{
+		ApproximateCreationDateTime: events.SecondsEpochTime{Time: s.Start.Add(time.Duration(s.seq) * time.Second)},
+		Keys:                        keys,
+		OldImage:                    oldImage,
+		NewImage:                    newImage,
+		SequenceNumber:              seq,
+		StreamViewType:              s.ViewType,
+	}
+	change.SizeBytes = size(change)
+
+	s.records = append(s.records, events.DynamoDBEventRecord{
+		AWSRegion:      s.Region,
+		Change:         change,
+		EventID:        hex.EncodeToString(id[:16]),
+		EventName:      name,
+		EventSource:    "aws:dynamodb",
+		EventVersion:   "1.1",
+		EventSourceArn: s.ARN(),
+	})
+
+	return s
+}
+
+func (s *Stream) carries(image string) bool {
+	return s.ViewType == image || s.ViewType == dynamodb.StreamViewTypeNewAndOldImages
+}
+
+// Records returns the records built so far
+func (s *Stream) Records() []events.DynamoDBEventRecord {
+	return s.records
+}
+
+// Build returns the event holding every record, or the first builder error
+func (s *Stream) Build() (events.DynamoDBEvent, error) {
+	return events.DynamoDBEvent{Records: s.records}, s.err
+}
+
+// Event returns the event holding every record and panics on builder errors
+func (s *Stream) Event() events.DynamoDBEvent {
+	e, err := s.Build()
+	if err != nil {
+		panic(err)
+	}
+
+	return e
+}
+
+// Merge returns a single event holding the records of every stream, in order
+func Merge(streams ...*Stream) (events.DynamoDBEvent, error) {
+	var e events.DynamoDBEvent
+	for _, s := range streams {
+		if s.err != nil {
+			return e, s.err
+		}
+		e.Records = append(e.Records, s.records...)
+	}
+
+	return e, nil
+}
+
+// Keys returns the key attributes of a tagged model as a stream image
+func Keys(item interface{}) (map[string]events.DynamoDBAttributeValue, error) {
+	schema, err := dynamo.SchemaOf(item)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := services.MarshalStreamImage(item)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]events.DynamoDBAttributeValue, 2)
+	for _, f := range []*dynamo.Field{schema.PartitionKey, schema.SortKey} {
+		if f == nil {
+			continue
+		}
+		av, ok := image[f.Attribute]
+		if !ok || av.DataType() == events.DataTypeNull {
+			return nil, fmt.Errorf("dynamotest: key %s of %T is empty", f.Attribute, item)
+		}
+		keys[f.Attribute] = av
+	}
+
+	return keys, nil
+}
+
+// size approximates the stream record size from the JSON encoding of its images
+func size(change events.DynamoDBStreamRecord) int64 {
+	var n int
+	for _, image := range []map[string]events.DynamoDBAttributeValue{change.Keys, change.OldImage, change.NewImage} {
+		if b, err := json.Marshal(image); err == nil && image != nil {
+			n += len(b)
+		}
+	}
+
+	return int64(n)
+}
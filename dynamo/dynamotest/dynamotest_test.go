@@ -0,0 +1,112 @@
+package dynamotest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/dynamo"
+	"github.com/kraneware/kws/dynamo/dynamotest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestDynamoTest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DynamoDB Test Helpers Suite")
+}
+
+type order struct {
+	Customer string `json:"customer" dynamo:"pk"`
+	ID       string `json:"id" dynamo:"sk"`
+	Status   string `json:"status"`
+}
+
+var _ = Describe("Stream", func() {
+	o := order{Customer: "c1", ID: "o1", Status: "NEW"}
+	paid := order{Customer: "c1", ID: "o1", Status: "PAID"}
+
+	It("should build realistic records", func() {
+		e := dynamotest.NewStream("orders").Insert(o).Modify(o, paid).Remove(paid).Event()
+		Expect(e.Records).Should(HaveLen(3))
+
+		first, second := e.Records[0], e.Records[1]
+		Expect(first.EventName).Should(Equal(dynamo.EventInsert))
+		Expect(first.EventSource).Should(Equal("aws:dynamodb"))
+		Expect(dynamo.TableFromARN(first.EventSourceArn)).Should(Equal("orders"))
+		Expect(first.Change.SequenceNumber).Should(HaveLen(21))
+		Expect(first.Change.SequenceNumber < second.Change.SequenceNumber).Should(BeTrue())
+		Expect(first.Change.ApproximateCreationDateTime.Before(second.Change.ApproximateCreationDateTime.Time)).Should(BeTrue())
+		Expect(first.EventID).ShouldNot(Equal(second.EventID))
+		Expect(first.Change.Keys).Should(HaveLen(2))
+		Expect(first.Change.OldImage).Should(BeNil())
+		Expect(first.Change.SizeBytes).Should(BeNumerically(">", 0))
+		Expect(second.Change.OldImage["status"].String()).Should(Equal("NEW"))
+		Expect(second.Change.NewImage["status"].String()).Should(Equal("PAID"))
+		Expect(e.Records[2].Change.NewImage).Should(BeNil())
+	})
+
+	It("should honour the stream view type", func() {
+		e := dynamotest.NewStream("orders").WithViewType(dynamodb.StreamViewTypeKeysOnly).Modify(o, paid).Event()
+		Expect(e.Records[0].Change.OldImage).Should(BeNil())
+		Expect(e.Records[0].Change.NewImage).Should(BeNil())
+		Expect(e.Records[0].Change.Keys["id"].String()).Should(Equal("o1"))
+
+		e = dynamotest.NewStream("orders").WithViewType(dynamodb.StreamViewTypeNewImage).Modify(o, paid).Event()
+		Expect(e.Records[0].Change.OldImage).Should(BeNil())
+		Expect(e.Records[0].Change.NewImage).ShouldNot(BeNil())
+	})
+
+	It("should report models without keys", func() {
+		_, err := dynamotest.NewStream("orders").Insert(struct{ A string }{"a"}).Insert(o).Build()
+		Expect(err).ShouldNot(BeNil())
+		Expect(func() { dynamotest.NewStream("orders").Insert(1).Event() }).Should(Panic())
+
+		_, err = dynamotest.Merge(dynamotest.NewStream("a").Insert(o), dynamotest.NewStream("b").Insert(1))
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("should report empty key fields", func() {
+		keys, err := dynamotest.Keys(order{Customer: "c1"})
+		Expect(err).Should(MatchError(ContainSubstring("key id")))
+		Expect(keys).Should(BeNil())
+
+		_, err = dynamotest.NewStream("orders").Insert(order{ID: "o1"}).Build()
+		Expect(err).Should(MatchError(ContainSubstring("key customer")))
+
+		keys, err = dynamotest.Keys(o)
+		Expect(err).Should(BeNil())
+		Expect(keys["id"].String()).Should(Equal("o1"))
+	})
+
+	It("should drive a dispatcher end to end", func() {
+		var statuses []string
+		d := dynamo.NewStreamDispatcher()
+		Expect(d.Handle("orders", order{}, func(_ context.Context, r *dynamo.StreamRecord) error {
+			if r.New == nil {
+				return errors.New("no new image")
+			}
+			statuses = append(statuses, r.New.(*order).Status)
+			return nil
+		}, dynamo.EventInsert, dynamo.EventModify)).Should(BeNil())
+
+		e, err := dynamotest.Merge(
+			dynamotest.NewStream("orders").Insert(o).Modify(o, paid).Remove(paid),
+			dynamotest.NewStream("customers").Insert(o),
+		)
+		Expect(err).Should(BeNil())
+
+		resp, err := d.Dispatch(context.Background(), e)
+		Expect(err).Should(BeNil())
+		Expect(resp.BatchItemFailures).Should(BeEmpty())
+		Expect(statuses).Should(Equal([]string{"NEW", "PAID"}))
+
+		e = dynamotest.NewStream("orders").WithViewType(dynamodb.StreamViewTypeKeysOnly).Insert(o).Event()
+		resp, _ = d.Dispatch(context.Background(), e)
+		Expect(resp.BatchItemFailures).Should(Equal([]events.DynamoDBBatchItemFailure{
+			{ItemIdentifier: e.Records[0].Change.SequenceNumber},
+		}))
+	})
+})
@@ -0,0 +1,515 @@
+package dynamo
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// EntityDef declares how an entity type is laid out in a single table design. Key templates
+// mix literal text with {Field} placeholders naming fields of the model; time.Time fields take
+// an optional layout, e.g. "ORDER#{Created:2006-01-02}#{ID}".
+type EntityDef struct {
+	// Name is stored in the discriminator attribute and selects the type on read
+	Name string
+
+	PK string
+	SK string
+
+	// Indexes maps a GSI name (as declared with Registry.Index) to its key templates
+	Indexes map[string]IndexKeys
+}
+
+// IndexKeys holds the partition and sort key of an index. On a Registry the values are
+// attribute names, on an EntityDef they are key templates.
+type IndexKeys struct {
+	PK string
+	SK string
+}
+
+// Registry maps the entity types sharing a single table to their key templates
+type Registry struct {
+	PartitionKey  string
+	SortKey       string
+	TypeAttribute string
+
+	indexes map[string]IndexKeys
+	byName  map[string]*entity
+	byType  map[reflect.Type]*entity
+}
+
+type entity struct {
+	name  string
+	typ   reflect.Type
+	attrs map[string]*KeyTemplate
+}
+
+// KeyTemplate is a compiled key template bound to a model type
+type KeyTemplate struct {
+	raw   string
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	field   *reflect.StructField
+	layout  string
+}
+
+// NewRegistry creates a registry using the attributes PK, SK and type
+func NewRegistry() *Registry {
+	return &Registry{
+		PartitionKey:  "PK",
+		SortKey:       "SK",
+		TypeAttribute: "type",
+		indexes:       make(map[string]IndexKeys),
+		byName:        make(map[string]*entity),
+		byType:        make(map[reflect.Type]*entity),
+	}
+}
+
+// Index declares the key attributes of a GSI shared by the entities, e.g.
+// r.Index("GSI1", "GSI1PK", "GSI1SK")
+func (r *Registry) Index(name, pk, sk string) *Registry {
+	r.indexes[name] = IndexKeys{PK: pk, SK: sk}
+	return r
+}
+
+// Register adds an entity type (given as a struct or pointer to struct) to the registry
+func (r *Registry) Register(model interface{}, def EntityDef) error {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("dynamo: entity model must be a struct, got %T", model)
+	}
+
+	if def.Name == "" || def.PK == "" {
+		return fmt.Errorf("dynamo: entity %s needs a name and a PK template", t)
+	}
+
+	if _, ok := r.byName[def.Name]; ok {
+		return fmt.Errorf("dynamo: entity %s is already registered", def.Name)
+	}
+
+	e := &entity{name: def.Name, typ: t, attrs: make(map[string]*KeyTemplate)}
+
+	templates := map[string]string{r.PartitionKey: def.PK, r.SortKey: def.SK}
+	for index, keys := range def.Indexes {
+		attrs, ok := r.indexes[index]
+		if !ok {
+			return fmt.Errorf("dynamo: index %s used by %s is not declared on the registry", index, def.Name)
+		}
+		templates[attrs.PK] = keys.PK
+		templates[attrs.SK] = keys.SK
+	}
+
+	for attr, raw := range templates {
+		if raw == "" || attr == "" {
+			continue
+		}
+
+		kt, err := ParseKeyTemplate(t, raw)
+		if err != nil {
+			return fmt.Errorf("dynamo: entity %s attribute %s: %w", def.Name, attr, err)
+		}
+		e.attrs[attr] = kt
+	}
+
+	r.byName[def.Name] = e
+	r.byType[t] = e
+
+	return nil
+}
+
+// ParseKeyTemplate compiles a key template against the fields of the model type
+func ParseKeyTemplate(t reflect.Type, raw string) (*KeyTemplate, error) {
+	kt := &KeyTemplate{raw: raw}
+
+	rest := raw
+	for rest != "" {
+		open := strings.Index(rest, "{")
+		if open < 0 {
+			kt.parts = append(kt.parts, templatePart{literal: rest})
+			break
+		}
+
+		if open > 0 {
+			kt.parts = append(kt.parts, templatePart{literal: rest[:open]})
+		}
+
+		end := strings.Index(rest[open:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in %q", raw)
+		}
+
+		name, layout := rest[open+1:open+end], ""
+		if i := strings.Index(name, ":"); i >= 0 {
+			name, layout = name[:i], name[i+1:]
+		}
+
+		sf, ok := t.FieldByName(name)
+		if !ok || sf.PkgPath != "" {
+			return nil, fmt.Errorf("%s has no exported field %s", t, name)
+		}
+		if !supportedKeyKind(sf.Type) {
+			return nil, fmt.Errorf("field %s of type %s cannot be used in a key", name, sf.Type)
+		}
+
+		if n := len(kt.parts); n > 0 && kt.parts[n-1].field != nil {
+			return nil, fmt.Errorf("placeholders %s need a literal separator in %q", name, raw)
+		}
+
+		if layout == "" && sf.Type == timeType {
+			layout = time.RFC3339
+		}
+
+		kt.parts = append(kt.parts, templatePart{field: &sf, layout: layout})
+		rest = rest[open+end+1:]
+	}
+
+	return kt, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{}) // nolint:gochecknoglobals
+
+func supportedKeyKind(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+// String returns the raw template
+func (kt *KeyTemplate) String() string {
+	return kt.raw
+}
+
+// Expand renders the template from the fields of v. When prefix is set, rendering stops at the
+// first placeholder whose field is empty, which is useful for begins_with key conditions.
+// complete reports whether every placeholder had a value.
+//
+// A field holding its zero value is empty, so a segment such as ORDER#0 or a false flag needs a
+// pointer field (*int, *bool): those are empty only when nil.
+func (kt *KeyTemplate) Expand(v reflect.Value, prefix bool) (out string, complete bool) {
+	var sb strings.Builder
+	complete = true
+
+	for _, p := range kt.parts {
+		if p.field == nil {
+			sb.WriteString(p.literal)
+			continue
+		}
+
+		fv, ok := keyField(v.FieldByIndex(p.field.Index))
+		if !ok {
+			complete = false
+			if prefix {
+				break
+			}
+		}
+		if fv.IsValid() {
+			sb.WriteString(formatKeyValue(fv, p.layout))
+		}
+	}
+
+	return sb.String(), complete
+}
+
+// keyField returns the value of a key field and whether it is set: pointers when they are not
+// nil, other fields when they are not zero
+func keyField(fv reflect.Value) (reflect.Value, bool) {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return reflect.Value{}, false
+		}
+		return fv.Elem(), true
+	}
+
+	return fv, !fv.IsZero()
+}
+
+// Parse extracts the placeholder values of key into the fields of v (an addressable struct)
+func (kt *KeyTemplate) Parse(key string, v reflect.Value) error {
+	pos := 0
+	for i, p := range kt.parts {
+		if p.field == nil {
+			if !strings.HasPrefix(key[pos:], p.literal) {
+				return fmt.Errorf("dynamo: key %q does not match template %q", key, kt.raw)
+			}
+			pos += len(p.literal)
+			continue
+		}
+
+		end := len(key)
+		if i+1 < len(kt.parts) {
+			next := strings.Index(key[pos:], kt.parts[i+1].literal)
+			if next < 0 {
+				return fmt.Errorf("dynamo: key %q does not match template %q", key, kt.raw)
+			}
+			end = pos + next
+		}
+
+		if err := parseKeyValue(key[pos:end], v.FieldByIndex(p.field.Index), p.layout); err != nil {
+			return fmt.Errorf("dynamo: field %s of key %q: %w", p.field.Name, key, err)
+		}
+		pos = end
+	}
+
+	if pos != len(key) {
+		return fmt.Errorf("dynamo: key %q does not match template %q", key, kt.raw)
+	}
+
+	return nil
+}
+
+func formatKeyValue(v reflect.Value, layout string) string {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).UTC().Format(layout)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+func parseKeyValue(s string, v reflect.Value, layout string) error {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := parseKeyValue(s, p.Elem(), layout); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	if v.Type() == timeType {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			v.Set(reflect.ValueOf(t))
+		}
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	}
+
+	return nil
+}
+
+func (r *Registry) entityOf(item interface{}) (*entity, reflect.Value, error) {
+	v := reflect.ValueOf(item)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	e, ok := r.byType[v.Type()]
+	if !ok {
+		return nil, v, fmt.Errorf("dynamo: %T is not a registered entity", item)
+	}
+
+	return e, v, nil
+}
+
+// Keys renders every key attribute (table and index keys) of the item. Every placeholder of the
+// table keys must have a value. Index keys whose placeholders are not all set are left out so
+// the item stays out of sparse indexes.
+func (r *Registry) Keys(item interface{}) (map[string]string, error) {
+	e, v, err := r.entityOf(item)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]string, len(e.attrs))
+	for attr, kt := range e.attrs {
+		s, complete := kt.Expand(v, false)
+		if attr == r.PartitionKey || attr == r.SortKey {
+			if !complete {
+				return nil, fmt.Errorf("dynamo: key %s of %s has an empty field in %q", attr, e.name, kt)
+			}
+		} else if !complete {
+			continue
+		}
+		keys[attr] = s
+	}
+
+	return keys, nil
+}
+
+// Key returns the primary key attribute map of the item
+func (r *Registry) Key(item interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	keys, err := r.Keys(item)
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]*dynamodb.AttributeValue{
+		r.PartitionKey: {S: aws.String(keys[r.PartitionKey])},
+	}
+	if sk, ok := keys[r.SortKey]; ok {
+		out[r.SortKey] = &dynamodb.AttributeValue{S: aws.String(sk)}
+	}
+
+	return out, nil
+}
+
+// Prefix renders the template of the given key attribute up to the first empty field of item,
+// e.g. "ORDER#" for use with begins_with
+func (r *Registry) Prefix(item interface{}, attr string) (string, error) {
+	e, v, err := r.entityOf(item)
+	if err != nil {
+		return "", err
+	}
+
+	kt, ok := e.attrs[attr]
+	if !ok {
+		return "", fmt.Errorf("dynamo: entity %s has no template for %s", e.name, attr)
+	}
+
+	s, _ := kt.Expand(v, true)
+
+	return s, nil
+}
+
+// Marshal converts the item to an attribute map including its keys and discriminator
+func (r *Registry) Marshal(item interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	e, _, err := r.entityOf(item)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := r.Keys(item)
+	if err != nil {
+		return nil, err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return nil, err
+	}
+
+	for attr, s := range keys {
+		av[attr] = &dynamodb.AttributeValue{S: aws.String(s)}
+	}
+	av[r.TypeAttribute] = &dynamodb.AttributeValue{S: aws.String(e.name)}
+
+	return av, nil
+}
+
+// Unmarshal decodes an item into a new value of the type named by its discriminator, filling
+// the key fields from the key attributes. The result is a pointer to the entity struct.
+func (r *Registry) Unmarshal(av map[string]*dynamodb.AttributeValue) (interface{}, error) {
+	name := ""
+	if t, ok := av[r.TypeAttribute]; ok && t.S != nil {
+		name = *t.S
+	}
+
+	e, ok := r.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("dynamo: unknown entity %q", name)
+	}
+
+	out := reflect.New(e.typ)
+	if err := dynamodbattribute.UnmarshalMap(av, out.Interface()); err != nil {
+		return nil, err
+	}
+
+	for attr, kt := range e.attrs {
+		if a, ok := av[attr]; ok && a.S != nil {
+			if err := kt.Parse(*a.S, out.Elem()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return out.Interface(), nil
+}
+
+// UnmarshalAll decodes a heterogeneous list of items, e.g. the result of a Query over a
+// partition holding several entity types
+func (r *Registry) UnmarshalAll(items []map[string]*dynamodb.AttributeValue) ([]interface{}, error) {
+	out := make([]interface{}, 0, len(items))
+	for _, av := range items {
+		v, err := r.Unmarshal(av)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+
+	return out, nil
+}
+
+// Query runs the query and decodes every item by its discriminator
+func (r *Registry) Query(ctx aws.Context, input *dynamodb.QueryInput, opts ...Option) ([]interface{}, error) {
+	it := Query(ctx, input, opts...)
+	defer it.Close()
+
+	var out []interface{}
+	for it.Next() {
+		v, err := r.Unmarshal(it.Item())
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+
+	return out, it.Err()
+}
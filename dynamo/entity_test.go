@@ -0,0 +1,159 @@
+package dynamo_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type user struct {
+	UserID string `json:"-"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+}
+
+type userOrder struct {
+	UserID  string    `json:"-"`
+	Created time.Time `json:"-"`
+	OrderID string    `json:"-"`
+	Amount  int       `json:"amount"`
+}
+
+type invoice struct {
+	AccountID string `json:"-"`
+	Number    *int   `json:"-"`
+	Paid      *bool  `json:"-"`
+	Total     int    `json:"-"`
+}
+
+var _ = Describe("Entity registry", func() {
+	var reg *dynamo.Registry
+
+	BeforeEach(func() {
+		reg = dynamo.NewRegistry().Index("GSI1", "GSI1PK", "GSI1SK")
+		Expect(reg.Register(user{}, dynamo.EntityDef{
+			Name:    "User",
+			PK:      "USER#{UserID}",
+			SK:      "PROFILE",
+			Indexes: map[string]dynamo.IndexKeys{"GSI1": {PK: "EMAIL#{Email}", SK: "USER"}},
+		})).Should(BeNil())
+		Expect(reg.Register(&userOrder{}, dynamo.EntityDef{
+			Name: "Order",
+			PK:   "USER#{UserID}",
+			SK:   "ORDER#{Created:2006-01-02}#{OrderID}",
+		})).Should(BeNil())
+	})
+
+	It("should compose keys on write", func() {
+		o := userOrder{UserID: "123", Created: time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC), OrderID: "abc", Amount: 7}
+		keys, err := reg.Keys(o)
+		Expect(err).Should(BeNil())
+		Expect(keys).Should(Equal(map[string]string{"PK": "USER#123", "SK": "ORDER#2022-04-25#abc"}))
+
+		av, err := reg.Marshal(&o)
+		Expect(err).Should(BeNil())
+		Expect(*av["type"].S).Should(Equal("Order"))
+		Expect(*av["amount"].N).Should(Equal("7"))
+
+		key, err := reg.Key(user{UserID: "123"})
+		Expect(err).Should(BeNil())
+		Expect(*key["SK"].S).Should(Equal("PROFILE"))
+
+		prefix, err := reg.Prefix(userOrder{UserID: "123"}, "SK")
+		Expect(err).Should(BeNil())
+		Expect(prefix).Should(Equal("ORDER#"))
+	})
+
+	It("should expand zero values of pointer fields", func() {
+		Expect(reg.Register(invoice{}, dynamo.EntityDef{
+			Name:    "Invoice",
+			PK:      "ACCOUNT#{AccountID}",
+			SK:      "INVOICE#{Number}#{Paid}",
+			Indexes: map[string]dynamo.IndexKeys{"GSI1": {PK: "TOTAL#{Total}", SK: "INVOICE"}},
+		})).Should(BeNil())
+
+		number, paid := 0, false
+		inv := invoice{AccountID: "a1", Number: &number, Paid: &paid}
+		keys, err := reg.Keys(inv)
+		Expect(err).Should(BeNil())
+		Expect(keys["SK"]).Should(Equal("INVOICE#0#false"))
+
+		// a zero value of a plain field is empty
+		Expect(keys).ShouldNot(HaveKey("GSI1PK"))
+
+		av, err := reg.Marshal(inv)
+		Expect(err).Should(BeNil())
+		out, err := reg.Unmarshal(av)
+		Expect(err).Should(BeNil())
+		Expect(out).Should(Equal(&inv))
+
+		_, err = reg.Keys(invoice{AccountID: "a1", Number: &number})
+		Expect(err).Should(MatchError(ContainSubstring("key SK of Invoice")))
+		prefix, err := reg.Prefix(invoice{AccountID: "a1", Number: &number}, "SK")
+		Expect(err).Should(BeNil())
+		Expect(prefix).Should(Equal("INVOICE#0#"))
+	})
+
+	It("should leave items out of sparse indexes", func() {
+		keys, err := reg.Keys(user{UserID: "1"})
+		Expect(err).Should(BeNil())
+		Expect(keys).ShouldNot(HaveKey("GSI1PK"))
+		Expect(keys).Should(HaveKey("GSI1SK"))
+
+		keys, err = reg.Keys(user{UserID: "1", Email: "a@b.c"})
+		Expect(err).Should(BeNil())
+		Expect(keys["GSI1PK"]).Should(Equal("EMAIL#a@b.c"))
+	})
+
+	It("should decode a heterogeneous query by discriminator", func() {
+		fake := newFakeDynamo("PK", "SK")
+		for _, e := range []interface{}{
+			user{UserID: "123", Email: "a@b.c", Name: "Ann"},
+			userOrder{UserID: "123", Created: time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC), OrderID: "abc", Amount: 3},
+		} {
+			av, err := reg.Marshal(e)
+			Expect(err).Should(BeNil())
+			fake.store(av)
+		}
+
+		out, err := reg.Query(context.Background(), &dynamodb.QueryInput{TableName: aws.String("app")}, dynamo.WithClient(fake))
+		Expect(err).Should(BeNil())
+		Expect(out).Should(HaveLen(2))
+		Expect(out[0]).Should(Equal(&userOrder{UserID: "123", Created: time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC), OrderID: "abc", Amount: 3}))
+		Expect(out[1]).Should(Equal(&user{UserID: "123", Email: "a@b.c", Name: "Ann"}))
+	})
+
+	It("should reject invalid templates and keys", func() {
+		Expect(reg.Register(user{}, dynamo.EntityDef{Name: "User", PK: "X"})).ShouldNot(BeNil())
+		Expect(reg.Register(user{}, dynamo.EntityDef{Name: "U2", PK: "{Missing}"})).ShouldNot(BeNil())
+		Expect(reg.Register(user{}, dynamo.EntityDef{Name: "U3", PK: "{UserID}{Email}"})).ShouldNot(BeNil())
+		Expect(reg.Register(user{}, dynamo.EntityDef{Name: "U4", PK: "{UserID"})).ShouldNot(BeNil())
+		Expect(reg.Register(user{}, dynamo.EntityDef{Name: "U5", PK: "A", Indexes: map[string]dynamo.IndexKeys{"GSI9": {PK: "B"}}})).ShouldNot(BeNil())
+		Expect(reg.Register("nope", dynamo.EntityDef{Name: "U6", PK: "A"})).ShouldNot(BeNil())
+
+		_, err := reg.Keys(struct{}{})
+		Expect(err).ShouldNot(BeNil())
+
+		_, err = reg.Keys(user{Email: "a@b.c"})
+		Expect(err).Should(MatchError(ContainSubstring("USER#{UserID}")))
+		_, err = reg.Key(userOrder{UserID: "123", OrderID: "abc"})
+		Expect(err).Should(MatchError(ContainSubstring("key SK of Order")))
+		_, err = reg.Marshal(userOrder{UserID: "123", Created: time.Now()})
+		Expect(err).ShouldNot(BeNil())
+
+		_, err = reg.Unmarshal(map[string]*dynamodb.AttributeValue{"type": {S: aws.String("Nope")}})
+		Expect(err).ShouldNot(BeNil())
+
+		_, err = reg.Unmarshal(map[string]*dynamodb.AttributeValue{
+			"type": {S: aws.String("Order")},
+			"PK":   {S: aws.String("USER#1")},
+			"SK":   {S: aws.String("INVOICE#1")},
+		})
+		Expect(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,451 @@
+package dynamo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/kraneware/kws/services"
+)
+
+// Export line formats
+const (
+	// FormatDynamoJSON writes one {"Item": {...}} object per line with typed attribute values,
+	// the layout of the native DynamoDB export to S3
+	FormatDynamoJSON = "dynamodb-json"
+
+	// FormatJSON writes one plain JSON object per line. Sets become arrays, binary values
+	// become base64 strings and numbers keep their exact text.
+	FormatJSON = "json"
+)
+
+// ExportOptions configures Export
+type ExportOptions struct {
+	Table string
+
+	// Destination is a local path or an s3://bucket/key URL
+	Destination string
+
+	// Format defaults to FormatDynamoJSON
+	Format string
+
+	// Gzip compresses the output
+	Gzip bool
+
+	// Segments is the number of parallel scan segments, 4 by default
+	Segments int64
+
+	// Client defaults to services.DynamoDbClient() and Uploader to services.S3Uploader()
+	Client   dynamodbiface.DynamoDBAPI
+	Uploader s3manageriface.UploaderAPI
+}
+
+// ImportOptions configures Import
+type ImportOptions struct {
+	Table string
+
+	// Source is a local path or an s3://bucket/key URL
+	Source string
+
+	// Format defaults to FormatDynamoJSON
+	Format string
+
+	// Gzip decompresses the input; it is implied by a .gz suffix
+	Gzip bool
+
+	// WritesPerSecond caps the item write rate, unlimited when zero
+	WritesPerSecond int
+
+	// Checkpoint is a file recording how many lines were imported. An interrupted import
+	// started again with the same checkpoint skips the lines already written.
+	Checkpoint string
+
+	// Client defaults to services.DynamoDbClient() and S3 to services.S3Client()
+	Client dynamodbiface.DynamoDBAPI
+	S3     s3iface.S3API
+}
+
+type exportLine struct {
+	Item map[string]events.DynamoDBAttributeValue
+}
+
+// Export scans the whole table in parallel and writes one item per line. It returns the number
+// of items written.
+func Export(ctx aws.Context, opts ExportOptions) (int64, error) {
+	format, err := checkFormat(opts.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	segments := opts.Segments
+	if segments <= 0 {
+		segments = 4
+	}
+
+	w, finish, err := openDestination(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		out   io.Writer = w
+		gz    *gzip.Writer
+		count int64
+	)
+	if opts.Gzip {
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+	buf := bufio.NewWriter(out)
+	enc := json.NewEncoder(buf)
+
+	it := Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(opts.Table)}, WithClient(clientOrDefault(opts.Client)), Segments(segments))
+	for err == nil && it.Next() {
+		if err = encodeLine(enc, format, it.Item()); err == nil {
+			count++
+		}
+	}
+	it.Close()
+
+	if err == nil {
+		err = it.Err()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+
+	return count, finish(err)
+}
+
+func encodeLine(enc *json.Encoder, format string, item map[string]*dynamodb.AttributeValue) error {
+	if format == FormatJSON {
+		return enc.Encode(plainMap(item))
+	}
+
+	image, err := services.AttributeMapToStreamImage(item)
+	if err != nil {
+		return err
+	}
+
+	return enc.Encode(exportLine{Item: image})
+}
+
+// openDestination returns the writer for the export and a function that closes it and waits
+// for the upload, returning the first error
+func openDestination(ctx aws.Context, opts ExportOptions) (io.Writer, func(error) error, error) {
+	bucket, key, ok := parseS3URL(opts.Destination)
+	if !ok {
+		f, err := os.Create(opts.Destination)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func(err error) error {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}, nil
+	}
+
+	uploader := opts.Uploader
+	if uploader == nil {
+		uploader = services.S3Uploader()
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		_ = pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return pw, func(err error) error {
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			<-done
+			return err
+		}
+		_ = pw.Close()
+		return <-done
+	}, nil
+}
+
+// Import writes the items of an export back into a table with BatchWrite. It returns the
+// number of items written by this run.
+func Import(ctx aws.Context, opts ImportOptions) (int64, error) {
+	format, err := checkFormat(opts.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := openSource(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	var in io.Reader = r
+	if opts.Gzip || strings.HasSuffix(opts.Source, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	done, err := readCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	batchSize := 4 * MaxBatchWriteItems
+	if opts.WritesPerSecond > 0 && opts.WritesPerSecond < batchSize {
+		batchSize = opts.WritesPerSecond
+	}
+
+	var (
+		svc     = clientOrDefault(opts.Client)
+		scanner = bufio.NewScanner(in)
+		line    int64
+		written int64
+		reqs    []*dynamodb.WriteRequest
+		start   = time.Now()
+	)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	flush := func() error {
+		if len(reqs) == 0 {
+			return nil
+		}
+
+		report, err := BatchWrite(ctx, svc, opts.Table, reqs)
+		if err == nil && report.Failed() > 0 {
+			err = fmt.Errorf("dynamo: %d items were not written", report.Failed())
+		}
+		if err == nil {
+			written += int64(len(reqs))
+			reqs = nil
+			err = writeCheckpoint(opts.Checkpoint, line)
+		}
+		if err == nil && opts.WritesPerSecond > 0 {
+			due := time.Duration(float64(written) / float64(opts.WritesPerSecond) * float64(time.Second))
+			err = sleep(ctx, due-time.Since(start))
+		}
+
+		return err
+	}
+
+	for err == nil && scanner.Scan() {
+		line++
+		if line <= done || len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var item map[string]*dynamodb.AttributeValue
+		item, err = decodeLine(format, scanner.Bytes())
+		if err != nil {
+			err = fmt.Errorf("dynamo: line %d: %w", line, err)
+			break
+		}
+
+		reqs = append(reqs, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+		if len(reqs) >= batchSize {
+			err = flush()
+		}
+	}
+
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err == nil {
+		err = flush()
+	}
+
+	return written, err
+}
+
+func openSource(ctx aws.Context, opts ImportOptions) (io.ReadCloser, error) {
+	bucket, key, ok := parseS3URL(opts.Source)
+	if !ok {
+		return os.Open(opts.Source)
+	}
+
+	svc := opts.S3
+	if svc == nil {
+		svc = services.S3Client()
+	}
+
+	out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+func decodeLine(format string, b []byte) (map[string]*dynamodb.AttributeValue, error) {
+	if format == FormatJSON {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+
+		var v map[string]interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		return dynamodbattribute.MarshalMap(numbers(v))
+	}
+
+	var line exportLine
+	if err := json.Unmarshal(b, &line); err != nil {
+		return nil, err
+	}
+	if len(line.Item) == 0 {
+		return nil, errors.New("missing Item")
+	}
+
+	return services.StreamImageToAttributeMap(line.Item), nil
+}
+
+func readCheckpoint(path string) (int64, error) {
+	if path == "" {
+		return 0, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func writeCheckpoint(path string, line int64) error {
+	if path == "" {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(line, 10)), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func checkFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return FormatDynamoJSON, nil
+	case FormatDynamoJSON, FormatJSON:
+		return format, nil
+	}
+
+	return "", fmt.Errorf("dynamo: unknown export format %q", format)
+}
+
+func parseS3URL(u string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(u, "s3://") {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(u, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// plainMap converts an item to plain JSON values, keeping numbers exact
+func plainMap(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	out := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		out[k] = plainValue(v)
+	}
+
+	return out
+}
+
+func plainValue(av *dynamodb.AttributeValue) interface{} {
+	switch {
+	case av == nil || av.NULL != nil:
+		return nil
+	case av.S != nil:
+		return *av.S
+	case av.N != nil:
+		return json.Number(*av.N)
+	case av.B != nil:
+		return av.B
+	case av.BOOL != nil:
+		return *av.BOOL
+	case av.SS != nil:
+		return aws.StringValueSlice(av.SS)
+	case av.NS != nil:
+		ns := make([]json.Number, len(av.NS))
+		for i, n := range av.NS {
+			ns[i] = json.Number(aws.StringValue(n))
+		}
+		return ns
+	case av.BS != nil:
+		return av.BS
+	case av.L != nil:
+		l := make([]interface{}, len(av.L))
+		for i, v := range av.L {
+			l[i] = plainValue(v)
+		}
+		return l
+	case av.M != nil:
+		return plainMap(av.M)
+	}
+
+	return nil
+}
+
+// numbers replaces the json.Number values of a decoded document with dynamodbattribute.Number
+// so that they are stored as numbers rather than strings
+func numbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		return dynamodbattribute.Number(t)
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = numbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = numbers(e)
+		}
+	}
+
+	return v
+}
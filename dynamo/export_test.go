@@ -0,0 +1,140 @@
+package dynamo_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeS3 stores uploaded objects in memory and serves them back
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (f *fakeS3) UploadWithContext(_ aws.Context, in *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	b, err := ioutil.ReadAll(in.Body)
+	if err == nil {
+		f.objects[*in.Bucket+"/"+*in.Key] = b
+	}
+
+	return &s3manager.UploadOutput{}, err
+}
+
+func (f *fakeS3) GetObjectWithContext(_ aws.Context, in *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	b, ok := f.objects[*in.Bucket+"/"+*in.Key]
+	if !ok {
+		return nil, fmt.Errorf("no such key %s", *in.Key)
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (f *fakeS3) Upload(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	panic("not implemented")
+}
+
+var _ = Describe("Export and Import", func() {
+	var (
+		ctx    context.Context
+		source *fakeDynamo
+		target *fakeDynamo
+		dir    string
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		source = newFakeDynamo("id")
+		target = newFakeDynamo("id")
+		dir, err = ioutil.TempDir("", "export")
+		Expect(err).Should(BeNil())
+
+		for i := 0; i < 30; i++ {
+			source.store(item{
+				"id":    {S: aws.String(fmt.Sprintf("i%02d", i))},
+				"n":     {N: aws.String("12345678901234567890.5")},
+				"bin":   {B: []byte{0, byte(i)}},
+				"ns":    {NS: aws.StringSlice([]string{"1", "2"})},
+				"empty": {NULL: aws.Bool(true)},
+				"m":     {M: item{"ok": {BOOL: aws.Bool(true)}, "l": {L: []*dynamodb.AttributeValue{{S: aws.String("x")}}}}},
+			})
+		}
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	It("should round trip DynamoDB JSON through a gzipped file", func() {
+		path := filepath.Join(dir, "orders.json.gz")
+		n, err := dynamo.Export(ctx, dynamo.ExportOptions{Table: "orders", Destination: path, Gzip: true, Client: source})
+		Expect(err).Should(BeNil())
+		Expect(n).Should(Equal(int64(30)))
+		Expect(source.count("Scan")).Should(Equal(4))
+
+		n, err = dynamo.Import(ctx, dynamo.ImportOptions{Table: "orders", Source: path, Client: target})
+		Expect(err).Should(BeNil())
+		Expect(n).Should(Equal(int64(30)))
+		Expect(target.items).Should(Equal(source.items))
+	})
+
+	It("should round trip plain JSON through S3", func() {
+		s3 := &fakeS3{objects: map[string][]byte{}}
+		n, err := dynamo.Export(ctx, dynamo.ExportOptions{
+			Table: "orders", Destination: "s3://bucket/orders.jsonl", Format: dynamo.FormatJSON, Client: source, Uploader: s3,
+		})
+		Expect(err).Should(BeNil())
+		Expect(n).Should(Equal(int64(30)))
+		Expect(string(s3.objects["bucket/orders.jsonl"])).Should(ContainSubstring(`"n":12345678901234567890.5`))
+
+		_, err = dynamo.Import(ctx, dynamo.ImportOptions{
+			Table: "orders", Source: "s3://bucket/orders.jsonl", Format: dynamo.FormatJSON, Client: target, S3: s3,
+		})
+		Expect(err).Should(BeNil())
+
+		got := target.items[target.keyString(item{"id": {S: aws.String("i07")}})]
+		Expect(*got["n"].N).Should(Equal("12345678901234567890.5"))
+		Expect(*got["bin"].S).Should(Equal("AAc="))
+		Expect(*got["m"].M["ok"].BOOL).Should(BeTrue())
+	})
+
+	It("should resume from a checkpoint and reject bad lines", func() {
+		path := filepath.Join(dir, "orders.jsonl")
+		_, err := dynamo.Export(ctx, dynamo.ExportOptions{Table: "orders", Destination: path, Client: source})
+		Expect(err).Should(BeNil())
+
+		checkpoint := filepath.Join(dir, "checkpoint")
+		Expect(ioutil.WriteFile(checkpoint, []byte("25"), 0o600)).Should(BeNil())
+
+		n, err := dynamo.Import(ctx, dynamo.ImportOptions{
+			Table: "orders", Source: path, Client: target, Checkpoint: checkpoint, WritesPerSecond: 1000,
+		})
+		Expect(err).Should(BeNil())
+		Expect(n).Should(Equal(int64(5)))
+		b, _ := ioutil.ReadFile(checkpoint)
+		Expect(strings.TrimSpace(string(b))).Should(Equal("30"))
+
+		bad := filepath.Join(dir, "bad.jsonl")
+		Expect(ioutil.WriteFile(bad, []byte("{\"Item\":{}}\n"), 0o600)).Should(BeNil())
+		_, err = dynamo.Import(ctx, dynamo.ImportOptions{Table: "orders", Source: bad, Client: target})
+		Expect(err).Should(MatchError(ContainSubstring("line 1")))
+
+		_, err = dynamo.Export(ctx, dynamo.ExportOptions{Table: "orders", Destination: path, Format: "csv"})
+		Expect(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,315 @@
+package dynamo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/google/uuid"
+	"github.com/jmespath/go-jmespath"
+	klambda "github.com/kraneware/kws/lambda"
+)
+
+var (
+	// ErrIdempotencyInProgress is returned for a duplicate event whose first delivery is still
+	// being processed
+	ErrIdempotencyInProgress = errors.New("dynamo: event is already being processed")
+
+	// ErrIdempotencyKeyMissing is returned when no idempotency key can be derived from an event
+	ErrIdempotencyKeyMissing = errors.New("dynamo: event has no idempotency key")
+)
+
+// Idempotency record states
+const (
+	StatusInProgress = "INPROGRESS"
+	StatusCompleted  = "COMPLETED"
+)
+
+const (
+	idemStatusAttribute   = "status"
+	idemResponseAttribute = "response"
+	idemExpiresAttribute  = "expires"
+	idemLockedAttribute   = "lockedUntil"
+	idemClaimAttribute    = "claim"
+)
+
+// KeyFunc derives the idempotency key of a raw event payload
+type KeyFunc func(payload []byte) (string, error)
+
+// JMESPathKey derives the idempotency key by evaluating a JMESPath expression against the JSON
+// event, e.g. "Records[0].messageId" or "[detail.orderId, detail.version]". Results that are
+// not strings are keyed on their JSON encoding.
+func JMESPathKey(expr string) (KeyFunc, error) {
+	path, err := jmespath.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(payload []byte) (string, error) {
+		var data interface{}
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return "", err
+		}
+
+		res, err := path.Search(data)
+		if err != nil {
+			return "", err
+		}
+
+		switch v := res.(type) {
+		case nil:
+			return "", ErrIdempotencyKeyMissing
+		case string:
+			return v, nil
+		}
+
+		b, err := json.Marshal(res)
+
+		return string(b), err
+	}, nil
+}
+
+// Idempotency makes Lambda handlers safe against duplicate deliveries. Each event is recorded
+// in a table (string partition key, with DynamoDB TTL enabled on "expires") under the SHA-256
+// of its key: INPROGRESS while the handler runs, then COMPLETED with the handler response.
+// Duplicates of a completed event get the stored response without running the handler again;
+// duplicates arriving while the first delivery runs fail with ErrIdempotencyInProgress. When
+// the handler fails, or its response cannot be recorded, the record is removed so that a retry
+// runs it again. A record claimed by another delivery in the meantime, because the handler ran
+// longer than Timeout, is left alone.
+//
+// Example:
+//
+//	key, _ := dynamo.JMESPathKey("detail.orderId")
+//	idem := dynamo.NewIdempotency("idempotency", key)
+//	lambda.StartHandler(idem.Wrap(handle))
+type Idempotency struct {
+	Table string
+	Key   KeyFunc
+
+	// PartitionKey is the string key attribute of the table, "id" by default
+	PartitionKey string
+
+	// Prefix scopes the keys, the Lambda function name by default
+	Prefix string
+
+	// TTL is how long completed events are remembered (one hour by default)
+	TTL time.Duration
+
+	// Timeout is how long an INPROGRESS record blocks duplicates, so that an event whose
+	// invocation crashed can be retried (the Lambda timeout should be lower; 15 minutes by
+	// default)
+	Timeout time.Duration
+
+	// Logger reports idempotency hits when set
+	Logger *klambda.Klogger
+
+	// Client defaults to services.DynamoDbClient() when nil
+	Client dynamodbiface.DynamoDBAPI
+}
+
+// NewIdempotency creates an idempotency layer on the given table with the default settings
+func NewIdempotency(table string, key KeyFunc) *Idempotency {
+	return &Idempotency{
+		Table:        table,
+		Key:          key,
+		PartitionKey: "id",
+		Prefix:       lambdacontext.FunctionName,
+		TTL:          time.Hour,
+		Timeout:      15 * time.Minute,
+	}
+}
+
+type idempotentHandler struct {
+	idem    *Idempotency
+	handler lambda.Handler
+}
+
+// Wrap returns a Lambda handler running handler (any function accepted by lambda.Start) at
+// most once per idempotency key
+func (i *Idempotency) Wrap(handler interface{}) lambda.Handler {
+	return &idempotentHandler{idem: i, handler: lambda.NewHandler(handler)}
+}
+
+func (i *Idempotency) keyAttribute() string {
+	if i.PartitionKey != "" {
+		return i.PartitionKey
+	}
+
+	return "id"
+}
+
+func (i *Idempotency) hash(key string) string {
+	sum := sha256.Sum256([]byte(i.Prefix + "#" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (i *Idempotency) record(id string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{i.keyAttribute(): {S: aws.String(id)}}
+}
+
+func (i *Idempotency) expires(now time.Time) *dynamodb.AttributeValue {
+	ttl := i.TTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.Add(ttl).Unix(), 10))}
+}
+
+func (h *idempotentHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	i := h.idem
+
+	key, err := i.Key(payload)
+	if err == nil && key == "" {
+		err = ErrIdempotencyKeyMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id := i.hash(key)
+	claim := uuid.New().String()
+	stored, err := i.begin(ctx, id, claim, time.Now())
+	if err != nil || stored != nil {
+		if stored != nil && i.Logger != nil {
+			i.Logger.WithField("idempotency_key", key).Info("duplicate event, returning stored response")
+		}
+		return stored, err
+	}
+
+	resp, err := h.handler.Invoke(ctx, payload)
+	if err != nil {
+		i.release(ctx, id, claim)
+		return nil, err
+	}
+
+	// the work is done: a duplicate may run it again, but this invocation succeeded
+	switch err = i.complete(ctx, id, claim, resp); {
+	case IsConditionalCheckFailed(err):
+		// the invocation outlived Timeout and a duplicate claimed the record; its outcome stands
+		if i.Logger != nil {
+			i.Logger.WithField("idempotency_key", key).Warn("idempotency record taken over by another delivery")
+		}
+	case err != nil:
+		if i.Logger != nil {
+			i.Logger.WithField("error", err).Warn("failed to record idempotent response")
+		}
+		i.release(ctx, id, claim)
+	}
+
+	return resp, nil
+}
+
+// release removes the INPROGRESS record of the given claim so that the event can be retried
+func (i *Idempotency) release(ctx aws.Context, id, claim string) {
+	cond := expression.Name(idemStatusAttribute).Equal(expression.Value(StatusInProgress)).
+		And(expression.Name(idemClaimAttribute).Equal(expression.Value(claim)))
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err == nil {
+		_, err = clientOrDefault(i.Client).DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+			TableName:                 aws.String(i.Table),
+			Key:                       i.record(id),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+	}
+
+	if err != nil && !IsConditionalCheckFailed(err) && i.Logger != nil {
+		i.Logger.WithField("error", err).Warn("failed to clear idempotency record")
+	}
+}
+
+// begin records the event as in progress under claim. It returns the stored response of a
+// completed duplicate, or ErrIdempotencyInProgress.
+func (i *Idempotency) begin(ctx aws.Context, id, claim string, now time.Time) ([]byte, error) {
+	timeout := i.Timeout
+	if timeout <= 0 {
+		timeout = 15 * time.Minute
+	}
+
+	nowSeconds := &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.Unix(), 10))}
+	cond := expression.AttributeNotExists(expression.Name(i.keyAttribute())).
+		Or(expression.Name(idemExpiresAttribute).LessThan(expression.Value(nowSeconds))).
+		Or(expression.Name(idemStatusAttribute).Equal(expression.Value(StatusInProgress)).
+			And(expression.Name(idemLockedAttribute).LessThan(expression.Value(millis(now)))))
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	item := i.record(id)
+	item[idemStatusAttribute] = &dynamodb.AttributeValue{S: aws.String(StatusInProgress)}
+	item[idemLockedAttribute] = millis(now.Add(timeout))
+	item[idemClaimAttribute] = &dynamodb.AttributeValue{S: aws.String(claim)}
+	item[idemExpiresAttribute] = i.expires(now)
+
+	svc := clientOrDefault(i.Client)
+	_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(i.Table),
+		Item:                      item,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if !IsConditionalCheckFailed(err) {
+		return nil, err
+	}
+
+	out, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(i.Table),
+		Key:            i.record(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if av, ok := out.Item[idemStatusAttribute]; ok && aws.StringValue(av.S) == StatusCompleted {
+		resp := []byte{}
+		if r, ok := out.Item[idemResponseAttribute]; ok {
+			resp = []byte(aws.StringValue(r.S))
+		}
+		return resp, nil
+	}
+
+	return nil, ErrIdempotencyInProgress
+}
+
+// complete records the response of the given claim, failing the condition when another
+// delivery has taken over the record
+func (i *Idempotency) complete(ctx aws.Context, id, claim string, resp []byte) error {
+	cond := expression.Name(idemStatusAttribute).Equal(expression.Value(StatusInProgress)).
+		And(expression.Name(idemClaimAttribute).Equal(expression.Value(claim)))
+	update := expression.Set(expression.Name(idemStatusAttribute), expression.Value(StatusCompleted)).
+		Set(expression.Name(idemResponseAttribute), expression.Value(string(resp))).
+		Set(expression.Name(idemExpiresAttribute), expression.Value(i.expires(time.Now()))).
+		Remove(expression.Name(idemLockedAttribute)).
+		Remove(expression.Name(idemClaimAttribute))
+	expr, err := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = clientOrDefault(i.Client).UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(i.Table),
+		Key:                       i.record(id),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+
+	return err
+}
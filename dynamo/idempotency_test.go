@@ -0,0 +1,164 @@
+package dynamo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/dynamo"
+	klambda "github.com/kraneware/kws/lambda"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+type orderEvent struct {
+	Detail struct {
+		OrderID string `json:"orderId"`
+	} `json:"detail"`
+}
+
+type failingUpdate struct {
+	*fakeDynamo
+}
+
+func (f failingUpdate) UpdateItemWithContext(aws.Context, *dynamodb.UpdateItemInput, ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	return nil, errors.New("update failed")
+}
+
+var _ = Describe("Idempotency", func() {
+	var (
+		ctx   context.Context
+		fake  *fakeDynamo
+		idem  *dynamo.Idempotency
+		calls int
+		fail  error
+		logs  *bytes.Buffer
+	)
+
+	handler := func(_ context.Context, e orderEvent) (string, error) {
+		calls++
+		return "done " + e.Detail.OrderID, fail
+	}
+	event := []byte(`{"detail":{"orderId":"o-1"}}`)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeDynamo("id")
+		calls, fail = 0, nil
+
+		key, err := dynamo.JMESPathKey("detail.orderId")
+		Expect(err).Should(BeNil())
+
+		logs = &bytes.Buffer{}
+		base := logrus.New()
+		base.SetOutput(logs)
+
+		idem = dynamo.NewIdempotency("idempotency", key)
+		idem.Client = fake
+		idem.Prefix = "orders"
+		idem.Logger = klambda.LambdaLoggerCustom(logrus.InfoLevel, base)
+	})
+
+	It("should derive keys with JMESPath", func() {
+		key, err := dynamo.JMESPathKey("[detail.orderId, detail.version]")
+		Expect(err).Should(BeNil())
+		k, err := key([]byte(`{"detail":{"orderId":"o-1","version":2}}`))
+		Expect(err).Should(BeNil())
+		Expect(k).Should(Equal(`["o-1",2]`))
+
+		key, _ = dynamo.JMESPathKey("detail.missing")
+		_, err = key(event)
+		Expect(err).Should(Equal(dynamo.ErrIdempotencyKeyMissing))
+
+		_, err = dynamo.JMESPathKey("detail.[")
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("should run the handler and record the response", func() {
+		resp, err := idem.Wrap(handler).Invoke(ctx, event)
+		Expect(err).Should(BeNil())
+		Expect(string(resp)).Should(Equal(`"done o-1"`))
+		Expect(calls).Should(Equal(1))
+
+		Expect(*fake.lastPut.Item["status"].S).Should(Equal(dynamo.StatusInProgress))
+		Expect(*fake.lastPut.Item["id"].S).Should(HaveLen(64))
+		Expect(fake.lastUpdate.ExpressionAttributeValues).Should(ContainElement(&dynamodb.AttributeValue{S: aws.String(`"done o-1"`)}))
+	})
+
+	It("should return the stored response of completed duplicates", func() {
+		_, err := idem.Wrap(handler).Invoke(ctx, event)
+		Expect(err).Should(BeNil())
+
+		id := fake.lastPut.Item["id"]
+		fake.store(item{
+			"id":       id,
+			"status":   {S: aws.String(dynamo.StatusCompleted)},
+			"response": {S: aws.String(`"done o-1"`)},
+		})
+		fake.failConditions = 1
+
+		resp, err := idem.Wrap(handler).Invoke(ctx, event)
+		Expect(err).Should(BeNil())
+		Expect(string(resp)).Should(Equal(`"done o-1"`))
+		Expect(calls).Should(Equal(1))
+		Expect(logs.String()).Should(ContainSubstring("duplicate event"))
+	})
+
+	It("should reject duplicates in progress", func() {
+		fake.failConditions = 1
+
+		_, err := idem.Wrap(handler).Invoke(ctx, event)
+		Expect(err).Should(Equal(dynamo.ErrIdempotencyInProgress))
+		Expect(calls).Should(Equal(0))
+	})
+
+	It("should clear the record when the handler fails", func() {
+		fail = errors.New("boom")
+		_, err := idem.Wrap(handler).Invoke(ctx, event)
+		Expect(err).ShouldNot(BeNil())
+		Expect(fake.lastDelete.Key["id"]).Should(Equal(fake.lastPut.Item["id"]))
+		Expect(fake.lastUpdate).Should(BeNil())
+
+		// only the record of this delivery is removed
+		Expect(fake.lastDelete.ConditionExpression).ShouldNot(BeNil())
+		Expect(fake.lastDelete.ExpressionAttributeValues).Should(ContainElement(fake.lastPut.Item["claim"]))
+		Expect(fake.lastDelete.ExpressionAttributeValues).Should(ContainElement(fake.lastPut.Item["status"]))
+	})
+
+	It("should return the response when it cannot be recorded", func() {
+		idem.Client = failingUpdate{fake}
+		resp, err := idem.Wrap(handler).Invoke(ctx, event)
+		Expect(err).Should(BeNil())
+		Expect(string(resp)).Should(Equal(`"done o-1"`))
+		Expect(fake.lastDelete.Key["id"]).Should(Equal(fake.lastPut.Item["id"]))
+		Expect(logs.String()).Should(ContainSubstring("failed to record"))
+	})
+
+	It("should leave a record taken over by another delivery", func() {
+		slow := func(_ context.Context, e orderEvent) (string, error) {
+			// the handler outlives Timeout and a duplicate claims the record
+			fake.setFailConditions(1)
+			return "done " + e.Detail.OrderID, nil
+		}
+
+		resp, err := idem.Wrap(slow).Invoke(ctx, event)
+		Expect(err).Should(BeNil())
+		Expect(string(resp)).Should(Equal(`"done o-1"`))
+
+		// the response is recorded only under this delivery's claim
+		Expect(fake.lastUpdate.ConditionExpression).ShouldNot(BeNil())
+		Expect(fake.lastUpdate.ExpressionAttributeValues).Should(ContainElement(fake.lastPut.Item["claim"]))
+		Expect(fake.count("DeleteItem")).Should(Equal(0))
+		Expect(logs.String()).Should(ContainSubstring("taken over"))
+	})
+
+	It("should fail events without a key", func() {
+		_, err := idem.Wrap(handler).Invoke(ctx, []byte(`{"detail":{}}`))
+		Expect(err).Should(Equal(dynamo.ErrIdempotencyKeyMissing))
+		Expect(fake.count("PutItem")).Should(Equal(0))
+	})
+})
@@ -0,0 +1,347 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Iterator walks the items returned by a Query or Scan, following LastEvaluatedKey until the
+// results, the Limit option or the context run out. Callers should Close an iterator they stop
+// reading before Next returns false.
+//
+// Example:
+//
+//	it := dynamo.Scan(ctx, input)
+//	defer it.Close()
+//	for it.Next() {
+//		var v Thing
+//		if err := it.Decode(&v); err != nil { ... }
+//	}
+//	if err := it.Err(); err != nil { ... }
+type Iterator struct {
+	ctx    aws.Context
+	parent aws.Context
+	cancel context.CancelFunc
+	schema keyNames
+	pages  <-chan page
+	items  []map[string]*dynamodb.AttributeValue
+	cur    map[string]*dynamodb.AttributeValue
+	last   map[string]*dynamodb.AttributeValue
+	keys   []string
+	limit  int64
+	count  int64
+	err    error
+	closed bool
+}
+
+type page struct {
+	items []map[string]*dynamodb.AttributeValue
+	last  map[string]*dynamodb.AttributeValue
+	err   error
+}
+
+// pager fetches the page starting at the given key, reading at most limit items when limit
+// is positive
+type pager func(ctx aws.Context, start map[string]*dynamodb.AttributeValue, limit int64) (page, error)
+
+// keyNames returns the names of the attributes of a LastEvaluatedKey
+type keyNames func(ctx aws.Context) ([]string, error)
+
+// describeKeys reads the key attributes of the table, and of the index when one is named, from
+// DescribeTable
+func describeKeys(svc dynamodbiface.DynamoDBAPI, table, index *string) keyNames {
+	return func(ctx aws.Context) ([]string, error) {
+		out, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: table})
+		if err != nil {
+			return nil, err
+		}
+
+		schemas := [][]*dynamodb.KeySchemaElement{out.Table.KeySchema}
+		for _, gsi := range out.Table.GlobalSecondaryIndexes {
+			if index != nil && aws.StringValue(gsi.IndexName) == *index {
+				schemas = append(schemas, gsi.KeySchema)
+			}
+		}
+		for _, lsi := range out.Table.LocalSecondaryIndexes {
+			if index != nil && aws.StringValue(lsi.IndexName) == *index {
+				schemas = append(schemas, lsi.KeySchema)
+			}
+		}
+
+		var names []string
+		seen := make(map[string]bool)
+		for _, schema := range schemas {
+			for _, k := range schema {
+				if name := aws.StringValue(k.AttributeName); !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+
+		return names, nil
+	}
+}
+
+// startKey returns the key of the next page, or the ExclusiveStartKey of the input for the
+// first one
+func startKey(start, input map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if start == nil {
+		return input
+	}
+
+	return start
+}
+
+// pageLimit returns the Limit of the next request: the smaller of the page size asked for and
+// the number of items still wanted
+func pageLimit(size *int64, limit int64) *int64 {
+	if limit > 0 && (size == nil || *size > limit) {
+		return aws.Int64(limit)
+	}
+
+	return size
+}
+
+// Query returns an iterator over every page of the query. Supported options are WithClient and
+// Limit.
+func Query(ctx aws.Context, input *dynamodb.QueryInput, opts ...Option) *Iterator {
+	o := applyOptions(opts)
+	svc := clientOrDefault(o.client)
+	in := *input
+
+	return startIterator(ctx, o.limit, describeKeys(svc, input.TableName, input.IndexName), []pager{
+		func(ctx aws.Context, start map[string]*dynamodb.AttributeValue, limit int64) (page, error) {
+			in.ExclusiveStartKey = startKey(start, input.ExclusiveStartKey)
+			in.Limit = pageLimit(input.Limit, limit)
+			out, err := svc.QueryWithContext(ctx, &in)
+			if err != nil {
+				return page{}, err
+			}
+			return page{items: out.Items, last: out.LastEvaluatedKey}, nil
+		},
+	})
+}
+
+// Scan returns an iterator over every page of the scan. When input.TotalSegments is set
+// without input.Segment, or the Segments option is given, every segment is scanned by its own
+// goroutine and the results are merged in arrival order. Supported options are WithClient,
+// Limit and Segments.
+func Scan(ctx aws.Context, input *dynamodb.ScanInput, opts ...Option) *Iterator {
+	o := applyOptions(opts)
+	svc := clientOrDefault(o.client)
+
+	total := o.segments
+	if total == 0 && input.TotalSegments != nil && input.Segment == nil {
+		total = *input.TotalSegments
+	}
+
+	var segments []*int64
+	if total > 1 {
+		for i := int64(0); i < total; i++ {
+			segments = append(segments, aws.Int64(i))
+		}
+	} else {
+		segments = []*int64{input.Segment}
+		total = 0
+	}
+
+	pagers := make([]pager, 0, len(segments))
+	for _, segment := range segments {
+		in := *input
+		if total > 0 {
+			in.Segment = segment
+			in.TotalSegments = aws.Int64(total)
+		}
+
+		pagers = append(pagers, func(ctx aws.Context, start map[string]*dynamodb.AttributeValue, limit int64) (page, error) {
+			in.ExclusiveStartKey = startKey(start, input.ExclusiveStartKey)
+			in.Limit = pageLimit(input.Limit, limit)
+			out, err := svc.ScanWithContext(ctx, &in)
+			if err != nil {
+				return page{}, err
+			}
+			return page{items: out.Items, last: out.LastEvaluatedKey}, nil
+		})
+	}
+
+	return startIterator(ctx, o.limit, describeKeys(svc, input.TableName, input.IndexName), pagers)
+}
+
+func startIterator(parent aws.Context, limit int64, schema keyNames, pagers []pager) *Iterator {
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan page, len(pagers))
+
+	var (
+		wg       sync.WaitGroup
+		produced int64
+	)
+
+	for _, p := range pagers {
+		wg.Add(1)
+		go func(p pager) {
+			defer wg.Done()
+
+			var start map[string]*dynamodb.AttributeValue
+			for {
+				// a sequential iterator reads no more than the limit, so that the key of its
+				// last page is the key of the last item returned
+				remaining := int64(0)
+				if limit > 0 && len(pagers) == 1 {
+					remaining = limit - atomic.LoadInt64(&produced)
+				}
+
+				pg, err := p(ctx, start, remaining)
+				pg.err = err
+
+				select {
+				case ch <- pg:
+				case <-ctx.Done():
+					return
+				}
+
+				if err != nil || len(pg.last) == 0 {
+					return
+				}
+				if limit > 0 && atomic.AddInt64(&produced, int64(len(pg.items))) >= limit {
+					return
+				}
+				start = pg.last
+			}
+		}(p)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	return &Iterator{
+		ctx:    ctx,
+		parent: parent,
+		cancel: cancel,
+		schema: schema,
+		pages:  ch,
+		limit:  limit,
+	}
+}
+
+// Next advances to the next item and reports whether there is one
+func (it *Iterator) Next() bool {
+	if it.closed || it.err != nil || (it.limit > 0 && it.count >= it.limit) {
+		it.Close()
+		return false
+	}
+
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+
+	for len(it.items) == 0 {
+		select {
+		case pg, ok := <-it.pages:
+			if !ok {
+				it.err = it.ctx.Err()
+				it.Close()
+				return false
+			}
+			if pg.err != nil {
+				it.err = pg.err
+				it.Close()
+				return false
+			}
+			it.items, it.last = pg.items, pg.last
+			if it.keys == nil {
+				for k := range pg.last {
+					it.keys = append(it.keys, k)
+				}
+			}
+		case <-it.ctx.Done():
+			if !it.closed {
+				it.err = it.ctx.Err()
+			}
+			it.Close()
+			return false
+		}
+	}
+
+	it.cur, it.items = it.items[0], it.items[1:]
+	it.count++
+
+	return true
+}
+
+// Item returns the raw attributes of the current item
+func (it *Iterator) Item() map[string]*dynamodb.AttributeValue {
+	return it.cur
+}
+
+// Decode unmarshals the current item into out
+func (it *Iterator) Decode(out interface{}) error {
+	if it.cur == nil {
+		return errors.New("dynamo: Decode called without a current item")
+	}
+
+	return dynamodbattribute.UnmarshalMap(it.cur, out)
+}
+
+// All decodes every remaining item into out (a pointer to a slice) and closes the iterator
+func (it *Iterator) All(out interface{}) error {
+	var items []map[string]*dynamodb.AttributeValue
+	for it.Next() {
+		items = append(items, it.cur)
+	}
+
+	if it.err != nil {
+		return it.err
+	}
+
+	return dynamodbattribute.UnmarshalListOfMaps(items, out)
+}
+
+// LastEvaluatedKey returns the key of the last item read, or nil once every item was read. For
+// a sequential iterator it can be used as ExclusiveStartKey to resume later. Stopping within
+// the last page may need the key schema of the table, read with DescribeTable; when that fails
+// LastEvaluatedKey returns nil and Err reports why.
+func (it *Iterator) LastEvaluatedKey() map[string]*dynamodb.AttributeValue {
+	if len(it.items) == 0 || it.cur == nil {
+		return it.last
+	}
+
+	if len(it.keys) == 0 {
+		keys, err := it.schema(it.parent)
+		if err != nil {
+			it.err = err
+			return nil
+		}
+		it.keys = keys
+	}
+
+	// stopped within a page: resume after the current item, not after the page
+	key := make(map[string]*dynamodb.AttributeValue, len(it.keys))
+	for _, k := range it.keys {
+		key[k] = it.cur[k]
+	}
+
+	return key
+}
+
+// Err returns the first error encountered while iterating
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close stops any outstanding page requests
+func (it *Iterator) Close() {
+	it.closed = true
+	it.cancel()
+}
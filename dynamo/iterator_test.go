@@ -0,0 +1,154 @@
+package dynamo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type failingScan struct {
+	*fakeDynamo
+}
+
+func (f failingScan) ScanWithContext(aws.Context, *dynamodb.ScanInput, ...request.Option) (*dynamodb.ScanOutput, error) {
+	return nil, errors.New("scan failed")
+}
+
+var _ = Describe("Iterator", func() {
+	var (
+		ctx  context.Context
+		fake *fakeDynamo
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeDynamo("customer", "id")
+		fake.pageSize = 3
+
+		table, err := dynamo.NewTable("orders", order{})
+		Expect(err).Should(BeNil())
+		table.Client = fake
+
+		for i := 0; i < 20; i++ {
+			Expect(table.Put(ctx, order{Customer: "c1", ID: fmt.Sprintf("o%02d", i), Total: i})).Should(BeNil())
+		}
+	})
+
+	It("should iterate a query across pages and decode items", func() {
+		it := dynamo.Query(ctx, &dynamodb.QueryInput{TableName: aws.String("orders")}, dynamo.WithClient(fake))
+		defer it.Close()
+
+		var seen []int
+		for it.Next() {
+			var o order
+			Expect(it.Decode(&o)).Should(BeNil())
+			seen = append(seen, o.Total)
+		}
+		Expect(it.Err()).Should(BeNil())
+		Expect(seen).Should(HaveLen(20))
+		Expect(seen[19]).Should(Equal(19))
+		Expect(it.LastEvaluatedKey()).Should(BeEmpty())
+		Expect(it.Next()).Should(BeFalse())
+	})
+
+	It("should stop at the item limit", func() {
+		it := dynamo.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String("orders")}, dynamo.WithClient(fake), dynamo.Limit(4))
+
+		var out []order
+		Expect(it.All(&out)).Should(BeNil())
+		Expect(out).Should(HaveLen(4))
+		Expect(it.LastEvaluatedKey()).ShouldNot(BeEmpty())
+	})
+
+	It("should resume after the last item read", func() {
+		input := &dynamodb.ScanInput{TableName: aws.String("orders")}
+		it := dynamo.Scan(ctx, input, dynamo.WithClient(fake), dynamo.Limit(4))
+
+		var first []order
+		Expect(it.All(&first)).Should(BeNil())
+
+		resumed := *input
+		resumed.ExclusiveStartKey = it.LastEvaluatedKey()
+		var rest []order
+		Expect(dynamo.Scan(ctx, &resumed, dynamo.WithClient(fake)).All(&rest)).Should(BeNil())
+		Expect(rest).Should(HaveLen(16))
+		Expect(rest[0].ID).Should(Equal("o04"))
+
+		// stopping within a page resumes after the current item too
+		it = dynamo.Scan(ctx, input, dynamo.WithClient(fake))
+		for i := 0; i < 5; i++ {
+			Expect(it.Next()).Should(BeTrue())
+		}
+		it.Close()
+
+		resumed.ExclusiveStartKey = it.LastEvaluatedKey()
+		rest = nil
+		Expect(dynamo.Scan(ctx, &resumed, dynamo.WithClient(fake)).All(&rest)).Should(BeNil())
+		Expect(rest).Should(HaveLen(15))
+		Expect(rest[0].ID).Should(Equal("o05"))
+	})
+
+	It("should resume within the last page", func() {
+		fake.pageSize = 0
+		input := &dynamodb.ScanInput{TableName: aws.String("orders")}
+		it := dynamo.Scan(ctx, input, dynamo.WithClient(fake))
+		for i := 0; i < 5; i++ {
+			Expect(it.Next()).Should(BeTrue())
+		}
+		it.Close()
+
+		resumed := *input
+		resumed.ExclusiveStartKey = it.LastEvaluatedKey()
+		Expect(resumed.ExclusiveStartKey).Should(HaveLen(2))
+		Expect(fake.count("DescribeTable")).Should(Equal(1))
+
+		var rest []order
+		Expect(dynamo.Scan(ctx, &resumed, dynamo.WithClient(fake)).All(&rest)).Should(BeNil())
+		Expect(rest).Should(HaveLen(15))
+		Expect(rest[0].ID).Should(Equal("o05"))
+	})
+
+	It("should merge a parallel segmented scan", func() {
+		input := &dynamodb.ScanInput{TableName: aws.String("orders"), TotalSegments: aws.Int64(4)}
+		it := dynamo.Scan(ctx, input, dynamo.WithClient(fake))
+
+		var out []order
+		Expect(it.All(&out)).Should(BeNil())
+		Expect(out).Should(HaveLen(20))
+		Expect(fake.calls["Scan"]).Should(Equal(8))
+
+		seen := map[string]bool{}
+		for _, o := range out {
+			seen[o.ID] = true
+		}
+		Expect(seen).Should(HaveLen(20))
+	})
+
+	It("should scan a single segment when one is given", func() {
+		input := &dynamodb.ScanInput{TableName: aws.String("orders"), TotalSegments: aws.Int64(4), Segment: aws.Int64(1)}
+
+		var out []order
+		Expect(dynamo.Scan(ctx, input, dynamo.WithClient(fake)).All(&out)).Should(BeNil())
+		Expect(out).Should(HaveLen(5))
+	})
+
+	It("should surface request errors and cancellation", func() {
+		it := dynamo.Scan(ctx, &dynamodb.ScanInput{}, dynamo.WithClient(failingScan{fake}), dynamo.Segments(3))
+		Expect(it.Next()).Should(BeFalse())
+		Expect(it.Err()).Should(MatchError("scan failed"))
+		Expect(it.Decode(&order{})).ShouldNot(BeNil())
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		it = dynamo.Query(cctx, &dynamodb.QueryInput{}, dynamo.WithClient(fake))
+		Expect(it.Next()).Should(BeFalse())
+		Expect(it.Err()).Should(Equal(context.Canceled))
+	})
+})
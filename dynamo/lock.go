@@ -0,0 +1,292 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrLockHeld is returned when a lock is owned by someone else and its lease has not expired
+	ErrLockHeld = errors.New("dynamo: lock is held by another owner")
+
+	// ErrLockLost is reported once a lock could not be renewed before its lease ran out or was
+	// taken over by another owner
+	ErrLockLost = errors.New("dynamo: lock lost")
+)
+
+const (
+	lockOwnerAttribute = "owner"
+	lockLeaseAttribute = "leaseUntil"
+)
+
+// LockClient hands out named locks stored in a DynamoDB table. A lock item holds the owner
+// token and the lease expiry (unix milliseconds); a lock whose lease has expired may be taken
+// by anyone, so lease durations must be well above the clock skew between callers.
+//
+// Example:
+//
+//	locks := dynamo.NewLockClient("locks")
+//	lock, err := locks.Acquire(ctx, "nightly-report", time.Minute)
+//	if err != nil { ... }
+//	defer lock.Release(ctx)
+//	select {
+//	case <-lock.Done(): // lost the lock, stop working
+//	case <-work():
+//	}
+type LockClient struct {
+	Table string
+
+	// PartitionKey is the string key attribute of the table, "id" by default
+	PartitionKey string
+
+	// LeaseDuration is how long a lock stays valid without a heartbeat (20s by default)
+	LeaseDuration time.Duration
+
+	// HeartbeatInterval is how often held locks are renewed, a third of the lease by default
+	HeartbeatInterval time.Duration
+
+	// RetryInterval is how often Acquire retries a held lock (500ms by default)
+	RetryInterval time.Duration
+
+	// Client defaults to services.DynamoDbClient() when nil
+	Client dynamodbiface.DynamoDBAPI
+}
+
+// NewLockClient creates a lock client on the given table with the default settings
+func NewLockClient(table string) *LockClient {
+	return &LockClient{
+		Table:         table,
+		PartitionKey:  "id",
+		LeaseDuration: 20 * time.Second,
+		RetryInterval: 500 * time.Millisecond,
+	}
+}
+
+// Lock is a held lock. Its lease is renewed in the background until Release is called or
+// the lock is lost.
+type Lock struct {
+	Name  string
+	Owner string
+
+	c       *LockClient
+	done    chan struct{}
+	stop    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	err     error
+	expires time.Time
+}
+
+func (c *LockClient) lease() time.Duration {
+	if c.LeaseDuration > 0 {
+		return c.LeaseDuration
+	}
+
+	return 20 * time.Second
+}
+
+func (c *LockClient) heartbeat() time.Duration {
+	if c.HeartbeatInterval > 0 {
+		return c.HeartbeatInterval
+	}
+
+	return c.lease() / 3
+}
+
+func (c *LockClient) keyAttribute() string {
+	if c.PartitionKey != "" {
+		return c.PartitionKey
+	}
+
+	return "id"
+}
+
+func (c *LockClient) key(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{c.keyAttribute(): {S: aws.String(name)}}
+}
+
+func millis(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))}
+}
+
+// TryAcquire takes the named lock if it is free or its lease has expired, and returns
+// ErrLockHeld otherwise
+func (c *LockClient) TryAcquire(ctx aws.Context, name string) (*Lock, error) {
+	now := time.Now()
+	owner := uuid.New().String()
+
+	cond := expression.AttributeNotExists(expression.Name(c.keyAttribute())).
+		Or(expression.Name(lockLeaseAttribute).LessThan(expression.Value(millis(now))))
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	item := c.key(name)
+	item[lockOwnerAttribute] = &dynamodb.AttributeValue{S: aws.String(owner)}
+	item[lockLeaseAttribute] = millis(now.Add(c.lease()))
+
+	_, err = clientOrDefault(c.Client).PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(c.Table),
+		Item:                      item,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if IsConditionalCheckFailed(err) {
+		return nil, ErrLockHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lock{
+		Name:    name,
+		Owner:   owner,
+		c:       c,
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		expires: now.Add(c.lease()),
+	}
+	go l.renew()
+
+	return l, nil
+}
+
+// Acquire waits up to timeout for the named lock, retrying every RetryInterval. It returns
+// ErrLockHeld when the timeout elapses first.
+func (c *LockClient) Acquire(ctx aws.Context, name string, timeout time.Duration) (*Lock, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	retry := c.RetryInterval
+	if retry <= 0 {
+		retry = 500 * time.Millisecond
+	}
+
+	for {
+		l, err := c.TryAcquire(ctx, name)
+		if err != ErrLockHeld {
+			return l, err
+		}
+
+		if sleep(ctx, retry) != nil {
+			return nil, ErrLockHeld
+		}
+	}
+}
+
+// Done is closed when the lock is released or lost
+func (l *Lock) Done() <-chan struct{} {
+	return l.done
+}
+
+// Err returns ErrLockLost once the lock has been lost
+func (l *Lock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+func (l *Lock) ownerCondition() expression.ConditionBuilder {
+	return expression.Name(lockOwnerAttribute).Equal(expression.Value(l.Owner))
+}
+
+func (l *Lock) renew() {
+	ticker := time.NewTicker(l.c.heartbeat())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		err := l.extend(now)
+
+		l.mu.Lock()
+		switch {
+		case err == nil:
+			l.expires = now.Add(l.c.lease())
+		case IsConditionalCheckFailed(err) || !now.Before(l.expires):
+			l.err = ErrLockLost
+		}
+		lost := l.err != nil
+		l.mu.Unlock()
+
+		if lost {
+			l.finish()
+			return
+		}
+	}
+}
+
+func (l *Lock) extend(now time.Time) error {
+	update := expression.Set(expression.Name(lockLeaseAttribute), expression.Value(millis(now.Add(l.c.lease()))))
+	expr, err := expression.NewBuilder().WithCondition(l.ownerCondition()).WithUpdate(update).Build()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.c.heartbeat())
+	defer cancel()
+
+	_, err = clientOrDefault(l.c.Client).UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(l.c.Table),
+		Key:                       l.c.key(l.Name),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+
+	return err
+}
+
+func (l *Lock) finish() {
+	l.once.Do(func() {
+		close(l.stop)
+		close(l.done)
+	})
+}
+
+// Release stops the heartbeat and deletes the lock if it is still owned by this holder. It
+// returns ErrLockLost when another owner has taken the lock in the meantime.
+func (l *Lock) Release(ctx aws.Context) error {
+	select {
+	case <-l.done:
+		return l.Err()
+	default:
+	}
+	l.finish()
+
+	expr, err := expression.NewBuilder().WithCondition(l.ownerCondition()).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = clientOrDefault(l.c.Client).DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(l.c.Table),
+		Key:                       l.c.key(l.Name),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if IsConditionalCheckFailed(err) {
+		err = ErrLockLost
+	}
+
+	return err
+}
@@ -0,0 +1,69 @@
+package dynamo_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LockClient", func() {
+	var (
+		ctx   context.Context
+		fake  *fakeDynamo
+		locks *dynamo.LockClient
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeDynamo("id")
+		locks = dynamo.NewLockClient("locks")
+		locks.Client = fake
+		locks.RetryInterval = time.Millisecond
+	})
+
+	It("should take a free or expired lock and release it with the owner token", func() {
+		lock, err := locks.TryAcquire(ctx, "job")
+		Expect(err).Should(BeNil())
+		Expect(lock.Owner).ShouldNot(BeEmpty())
+		Expect(*fake.lastPut.ConditionExpression).Should(Equal("(attribute_not_exists (#0)) OR (#1 < :0)"))
+		Expect(*fake.lastPut.Item["owner"].S).Should(Equal(lock.Owner))
+
+		Expect(lock.Release(ctx)).Should(BeNil())
+		Expect(*fake.lastDelete.ExpressionAttributeValues[":0"].S).Should(Equal(lock.Owner))
+		Eventually(lock.Done()).Should(BeClosed())
+		Expect(lock.Err()).Should(BeNil())
+	})
+
+	It("should report held locks and wait for them", func() {
+		fake.failConditions = 1
+		_, err := locks.TryAcquire(ctx, "job")
+		Expect(err).Should(Equal(dynamo.ErrLockHeld))
+
+		fake.failConditions = 3
+		lock, err := locks.Acquire(ctx, "job", time.Second)
+		Expect(err).Should(BeNil())
+		Expect(fake.count("PutItem")).Should(Equal(5))
+		Expect(lock.Release(ctx)).Should(BeNil())
+
+		fake.failConditions = 1 << 20
+		_, err = locks.Acquire(ctx, "job", 20*time.Millisecond)
+		Expect(err).Should(Equal(dynamo.ErrLockHeld))
+	})
+
+	It("should renew the lease and notice when it is lost", func() {
+		locks.HeartbeatInterval = 5 * time.Millisecond
+
+		lock, err := locks.TryAcquire(ctx, "job")
+		Expect(err).Should(BeNil())
+		Eventually(func() int { return fake.count("UpdateItem") }).Should(BeNumerically(">=", 2))
+		Expect(lock.Err()).Should(BeNil())
+
+		fake.setFailConditions(1)
+		Eventually(lock.Done()).Should(BeClosed())
+		Expect(lock.Err()).Should(Equal(dynamo.ErrLockLost))
+		Expect(lock.Release(ctx)).Should(Equal(dynamo.ErrLockLost))
+	})
+})
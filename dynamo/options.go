@@ -0,0 +1,133 @@
+package dynamo
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// Option customises a single table operation
+type Option func(*options)
+
+type options struct {
+	index      string
+	consistent bool
+	limit      int64
+	descending bool
+	filter     *expression.ConditionBuilder
+	condition  *expression.ConditionBuilder
+	notExists  bool
+
+	concurrency int
+	retries     int
+	backoff     time.Duration
+
+	client   dynamodbiface.DynamoDBAPI
+	segments int64
+}
+
+func applyOptions(opts []Option) *options {
+	o := &options{
+		concurrency: 4,
+		retries:     8,
+		backoff:     50 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Index runs a Query or Scan against the named secondary index
+func Index(name string) Option {
+	return func(o *options) {
+		o.index = name
+	}
+}
+
+// ConsistentRead requests a strongly consistent read
+func ConsistentRead() Option {
+	return func(o *options) {
+		o.consistent = true
+	}
+}
+
+// Limit caps the total number of items returned across all pages
+func Limit(n int64) Option {
+	return func(o *options) {
+		o.limit = n
+	}
+}
+
+// Descending returns Query results in descending sort key order
+func Descending() Option {
+	return func(o *options) {
+		o.descending = true
+	}
+}
+
+// Filter applies a filter expression to a Query or Scan
+func Filter(cond expression.ConditionBuilder) Option {
+	return func(o *options) {
+		o.filter = &cond
+	}
+}
+
+// ifNotExists makes a Put conditional on its key not being taken, see PutIfNotExists
+func ifNotExists() Option {
+	return func(o *options) {
+		o.notExists = true
+	}
+}
+
+// If makes a Put, Update or Delete conditional on the given expression
+func If(cond expression.ConditionBuilder) Option {
+	return func(o *options) {
+		c := cond
+		if o.condition != nil {
+			c = o.condition.And(cond)
+		}
+		o.condition = &c
+	}
+}
+
+// Concurrency sets how many batch chunks are sent at the same time
+func Concurrency(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
+
+// Retries sets how many times unprocessed batch items are retried before being reported
+func Retries(n int) Option {
+	return func(o *options) {
+		o.retries = n
+	}
+}
+
+// Backoff sets the base delay of the exponential backoff between batch retries; zero retries
+// immediately
+func Backoff(base time.Duration) Option {
+	return func(o *options) {
+		o.backoff = base
+	}
+}
+
+// WithClient sets the DynamoDB client used by the package level helpers instead of
+// services.DynamoDbClient()
+func WithClient(svc dynamodbiface.DynamoDBAPI) Option {
+	return func(o *options) {
+		o.client = svc
+	}
+}
+
+// Segments splits a Scan in n segments read in parallel by separate goroutines
+func Segments(n int64) Option {
+	return func(o *options) {
+		o.segments = n
+	}
+}
@@ -0,0 +1,204 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// ErrRateLimiterContention is returned by Take when the bucket kept changing under concurrent
+// callers for every retry
+var ErrRateLimiterContention = errors.New("dynamo: rate limiter bucket is contended")
+
+const (
+	bucketTokensAttribute  = "tokens"
+	bucketUpdatedAttribute = "updatedAt"
+	bucketVersionAttribute = "version"
+)
+
+// RateLimiter is a token bucket shared by every caller using the same table and key. A bucket
+// holds up to Capacity tokens and refills at Rate tokens per second; each Take reads the
+// bucket, refills it for the elapsed time and writes it back with a conditional UpdateItem on
+// the version of the bucket, incremented by every write, so concurrent takers never spend the
+// same tokens twice.
+//
+// Example:
+//
+//	limiter := dynamo.NewRateLimiter("rate-limits", 10, 5) // bursts of 10, 5 per second
+//	if err := limiter.Wait(ctx, "partner-api", 1); err != nil { ... }
+type RateLimiter struct {
+	Table    string
+	Capacity int64
+	Rate     float64
+
+	// PartitionKey is the string key attribute of the table, "id" by default
+	PartitionKey string
+
+	// Retries bounds the attempts of Take when the bucket changes concurrently (8 by default)
+	Retries int
+
+	// Client defaults to services.DynamoDbClient() when nil
+	Client dynamodbiface.DynamoDBAPI
+}
+
+// NewRateLimiter creates a token bucket limiter on the given table
+func NewRateLimiter(table string, capacity int64, perSecond float64) *RateLimiter {
+	return &RateLimiter{
+		Table:        table,
+		Capacity:     capacity,
+		Rate:         perSecond,
+		PartitionKey: "id",
+		Retries:      8,
+	}
+}
+
+func (r *RateLimiter) keyAttribute() string {
+	if r.PartitionKey != "" {
+		return r.PartitionKey
+	}
+
+	return "id"
+}
+
+func (r *RateLimiter) key(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{r.keyAttribute(): {S: aws.String(name)}}
+}
+
+// Take removes n tokens from the bucket if they are available. Otherwise it returns false with
+// the time after which n tokens will have been refilled.
+func (r *RateLimiter) Take(ctx aws.Context, key string, n int64) (bool, time.Duration, error) {
+	if n > r.Capacity || r.Rate <= 0 {
+		return false, 0, fmt.Errorf("dynamo: cannot take %d tokens from a bucket of %d refilled at %g/s", n, r.Capacity, r.Rate)
+	}
+
+	svc := clientOrDefault(r.Client)
+	retries := r.Retries
+	if retries <= 0 {
+		retries = 8
+	}
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, backoff(10*time.Millisecond, attempt)); err != nil {
+				return false, 0, err
+			}
+		}
+
+		out, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(r.Table),
+			Key:            r.key(key),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return false, 0, err
+		}
+
+		now := time.Now()
+		tokens, version, err := r.refill(out.Item, now)
+		if err != nil {
+			return false, 0, err
+		}
+
+		if tokens < float64(n) {
+			wait := time.Duration((float64(n) - tokens) / r.Rate * float64(time.Second))
+			return false, wait, nil
+		}
+
+		err = r.store(ctx, svc, key, tokens-float64(n), now, version)
+		if err == nil {
+			return true, 0, nil
+		}
+		if !IsConditionalCheckFailed(err) {
+			return false, 0, err
+		}
+	}
+
+	return false, 0, ErrRateLimiterContention
+}
+
+// Wait blocks until n tokens could be taken from the bucket or ctx is done
+func (r *RateLimiter) Wait(ctx aws.Context, key string, n int64) error {
+	for {
+		ok, wait, err := r.Take(ctx, key, n)
+		if err != nil || ok {
+			return err
+		}
+
+		if err = sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// refill returns the tokens available at now and the stored version of the bucket, zero when
+// it has none
+func (r *RateLimiter) refill(item map[string]*dynamodb.AttributeValue, now time.Time) (float64, int64, error) {
+	var version int64
+	if av, ok := item[bucketVersionAttribute]; ok && av.N != nil {
+		v, err := strconv.ParseInt(*av.N, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		version = v
+	}
+
+	tokensAV, ok := item[bucketTokensAttribute]
+	updatedAV, ok2 := item[bucketUpdatedAttribute]
+	if !ok || !ok2 || tokensAV.N == nil || updatedAV.N == nil {
+		return float64(r.Capacity), version, nil
+	}
+
+	tokens, err := strconv.ParseFloat(*tokensAV.N, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	updated, err := strconv.ParseInt(*updatedAV.N, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	elapsed := float64(now.UnixNano()/int64(time.Millisecond)-updated) / 1000
+	if elapsed > 0 {
+		tokens += elapsed * r.Rate
+	}
+
+	return math.Min(tokens, float64(r.Capacity)), version, nil
+}
+
+// store writes the bucket if its version is still the one read
+func (r *RateLimiter) store(ctx aws.Context, svc dynamodbiface.DynamoDBAPI, key string, tokens float64, now time.Time, version int64) error {
+	cond := expression.AttributeNotExists(expression.Name(bucketVersionAttribute))
+	if version > 0 {
+		cond = expression.Name(bucketVersionAttribute).Equal(expression.Value(version))
+	}
+
+	// an idle bucket is full again after capacity/rate seconds and can expire
+	full := time.Duration(float64(r.Capacity) / r.Rate * float64(time.Second))
+	update := expression.Set(expression.Name(bucketTokensAttribute), expression.Value(&dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(tokens, 'f', -1, 64))})).
+		Set(expression.Name(bucketUpdatedAttribute), expression.Value(millis(now))).
+		Set(expression.Name(bucketVersionAttribute), expression.Value(version+1)).
+		Set(expression.Name(counterExpiresAttribute), expression.Value(now.Add(full+time.Minute).Unix()))
+
+	expr, err := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(r.Table),
+		Key:                       r.key(key),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+
+	return err
+}
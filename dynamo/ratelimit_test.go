@@ -0,0 +1,160 @@
+package dynamo_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// racingDynamo spends a token of the bucket right after the next read, without changing its
+// update time, as a concurrent taker writing within the same millisecond would
+type racingDynamo struct {
+	updatingDynamo
+	race *bool
+}
+
+func (f racingDynamo) GetItemWithContext(ctx aws.Context, in *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	out, err := f.updatingDynamo.GetItemWithContext(ctx, in, opts...)
+	if err != nil || !*f.race {
+		return out, err
+	}
+	*f.race = false
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	read := item{}
+	for k, v := range out.Item {
+		read[k] = v
+	}
+	out.Item = read
+
+	spent := item{}
+	for k, v := range read {
+		spent[k] = v
+	}
+	tokens, _ := strconv.ParseFloat(*read["tokens"].N, 64)
+	version, _ := strconv.ParseInt(*read["version"].N, 10, 64)
+	spent["tokens"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(tokens-1, 'f', -1, 64))}
+	spent["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(version+1, 10))}
+	f.store(spent)
+
+	return out, nil
+}
+
+var _ = Describe("RateLimiter", func() {
+	var (
+		ctx     context.Context
+		fake    *fakeDynamo
+		limiter *dynamo.RateLimiter
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeDynamo("id")
+		limiter = dynamo.NewRateLimiter("limits", 2, 1)
+		limiter.Client = updatingDynamo{fake}
+	})
+
+	It("should take tokens until the bucket is empty", func() {
+		for i := 0; i < 2; i++ {
+			ok, _, err := limiter.Take(ctx, "api", 1)
+			Expect(err).Should(BeNil())
+			Expect(ok).Should(BeTrue())
+		}
+		Expect(*fake.lastUpdate.ConditionExpression).Should(Equal("#0 = :0"))
+		Expect(*fake.items[fake.keyString(fake.lastUpdate.Key)]["version"].N).Should(Equal("2"))
+
+		ok, wait, err := limiter.Take(ctx, "api", 1)
+		Expect(err).Should(BeNil())
+		Expect(ok).Should(BeFalse())
+		Expect(wait).Should(BeNumerically("~", time.Second, 100*time.Millisecond))
+
+		_, _, err = limiter.Take(ctx, "api", 3)
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("should retry when the bucket changes concurrently", func() {
+		fake.failConditions = 2
+		ok, _, err := limiter.Take(ctx, "api", 1)
+		Expect(err).Should(BeNil())
+		Expect(ok).Should(BeTrue())
+		Expect(fake.count("UpdateItem")).Should(Equal(3))
+
+		limiter.Retries = 1
+		fake.failConditions = 5
+		_, _, err = limiter.Take(ctx, "api", 1)
+		Expect(err).Should(Equal(dynamo.ErrRateLimiterContention))
+	})
+
+	It("should detect a concurrent write within the same millisecond", func() {
+		limiter = dynamo.NewRateLimiter("limits", 5, 0.001)
+		race := false
+		limiter.Client = racingDynamo{updatingDynamo{fake}, &race}
+
+		ok, _, err := limiter.Take(ctx, "api", 1)
+		Expect(err).Should(BeNil())
+		Expect(ok).Should(BeTrue())
+
+		race = true
+		ok, _, err = limiter.Take(ctx, "api", 1)
+		Expect(err).Should(BeNil())
+		Expect(ok).Should(BeTrue())
+		Expect(fake.count("UpdateItem")).Should(Equal(3))
+
+		tokens, err := strconv.ParseFloat(*fake.items[fake.keyString(fake.lastUpdate.Key)]["tokens"].N, 64)
+		Expect(err).Should(BeNil())
+		Expect(tokens).Should(BeNumerically("~", 2, 0.01))
+	})
+
+	It("should never over-admit concurrent takers", func() {
+		limiter = dynamo.NewRateLimiter("limits", 5, 0.001)
+		limiter.Client = updatingDynamo{fake}
+		limiter.Retries = 20
+
+		var (
+			wg    sync.WaitGroup
+			taken int32
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				ok, _, err := limiter.Take(ctx, "api", 1)
+				Expect(err).Should(BeNil())
+				if ok {
+					atomic.AddInt32(&taken, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		Expect(taken).Should(Equal(int32(5)))
+	})
+
+	It("should wait for tokens", func() {
+		limiter = dynamo.NewRateLimiter("limits", 1, 50)
+		limiter.Client = updatingDynamo{fake}
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			Expect(limiter.Wait(ctx, "api", 1)).Should(BeNil())
+		}
+		Expect(time.Since(start)).Should(BeNumerically(">=", 30*time.Millisecond))
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		Expect(limiter.Wait(cancelled, "api", 1)).ShouldNot(BeNil())
+	})
+})
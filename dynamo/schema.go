@@ -0,0 +1,257 @@
+package dynamo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagName is the struct tag used to describe the key layout of a table model.
+//
+// Supported options (comma separated):
+//
+//	pk            partition key of the table
+//	sk            sort key of the table
+//	ttl           attribute DynamoDB uses for time to live expiry
+//	version       numeric attribute used for optimistic locking
+//	gsi=Name      partition key of the global secondary index Name
+//	gsi=Name:sk   sort key of the global secondary index Name
+//	lsi=Name      sort key of the local secondary index Name
+//
+// Example: ID string `json:"id" dynamo:"pk"`
+const TagName = "dynamo"
+
+var schemaCache sync.Map // nolint:gochecknoglobals
+
+// Field describes a struct field mapped to a DynamoDB attribute
+type Field struct {
+	Name      string
+	Attribute string
+	Index     []int
+	Type      reflect.Type
+}
+
+// SecondaryIndex describes a global or local secondary index declared on a model
+type SecondaryIndex struct {
+	Name         string
+	Local        bool
+	PartitionKey *Field
+	SortKey      *Field
+}
+
+// Schema is the key layout of a table model derived from its struct tags
+type Schema struct {
+	Type         reflect.Type
+	PartitionKey *Field
+	SortKey      *Field
+	TTL          *Field
+	Version      *Field
+	Indexes      map[string]*SecondaryIndex
+}
+
+// SchemaOf parses the dynamo struct tags of the given model (a struct or pointer to struct)
+func SchemaOf(model interface{}) (*Schema, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("dynamo: model must be a struct, got %T", model)
+	}
+
+	if s, ok := schemaCache.Load(t); ok {
+		return s.(*Schema), nil
+	}
+
+	s := &Schema{
+		Type:    t,
+		Indexes: make(map[string]*SecondaryIndex),
+	}
+
+	err := s.parseFields(t, nil)
+	if err == nil && s.PartitionKey == nil {
+		err = fmt.Errorf("dynamo: %s has no field tagged %s:\"pk\"", t, TagName)
+	}
+
+	if err == nil {
+		for name, idx := range s.Indexes {
+			if idx.Local {
+				idx.PartitionKey = s.PartitionKey
+			}
+			if idx.PartitionKey == nil {
+				err = fmt.Errorf("dynamo: index %s on %s has no partition key", name, t)
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	schemaCache.Store(t, s)
+
+	return s, nil
+}
+
+func (s *Schema) parseFields(t reflect.Type, parent []int) (err error) {
+	for i := 0; i < t.NumField() && err == nil; i++ {
+		sf := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Tag.Get(TagName) == "" {
+			err = s.parseFields(sf.Type, index)
+			continue
+		}
+
+		tag := sf.Tag.Get(TagName)
+		if tag == "" || sf.PkgPath != "" {
+			continue
+		}
+
+		f := &Field{
+			Name:      sf.Name,
+			Attribute: attributeName(sf),
+			Index:     index,
+			Type:      sf.Type,
+		}
+
+		for _, opt := range strings.Split(tag, ",") {
+			if err == nil {
+				err = s.applyOption(f, strings.TrimSpace(opt))
+			}
+		}
+	}
+
+	return err
+}
+
+func (s *Schema) applyOption(f *Field, opt string) error {
+	name, value := opt, ""
+	if i := strings.Index(opt, "="); i >= 0 {
+		name, value = opt[:i], opt[i+1:]
+	}
+
+	switch name {
+	case "":
+		return nil
+	case "pk":
+		return assign(&s.PartitionKey, f, "partition key")
+	case "sk":
+		return assign(&s.SortKey, f, "sort key")
+	case "ttl":
+		return assign(&s.TTL, f, "ttl")
+	case "version":
+		if f.ScalarType() != "N" {
+			return fmt.Errorf("dynamo: version field %s must be numeric", f.Name)
+		}
+		return assign(&s.Version, f, "version")
+	case "gsi":
+		indexName, role := value, "pk"
+		if i := strings.Index(value, ":"); i >= 0 {
+			indexName, role = value[:i], value[i+1:]
+		}
+		idx := s.index(indexName, false)
+		switch role {
+		case "pk":
+			return assign(&idx.PartitionKey, f, "partition key of "+indexName)
+		case "sk":
+			return assign(&idx.SortKey, f, "sort key of "+indexName)
+		}
+		return fmt.Errorf("dynamo: unknown key role %q for index %s", role, indexName)
+	case "lsi":
+		return assign(&s.index(value, true).SortKey, f, "sort key of "+value)
+	}
+
+	return fmt.Errorf("dynamo: unknown tag option %q on field %s", opt, f.Name)
+}
+
+func (s *Schema) index(name string, local bool) *SecondaryIndex {
+	idx, ok := s.Indexes[name]
+	if !ok {
+		idx = &SecondaryIndex{Name: name, Local: local}
+		s.Indexes[name] = idx
+	}
+
+	return idx
+}
+
+// Keys returns the partition and sort key fields of the table or of the named index
+func (s *Schema) Keys(index string) (pk, sk *Field, err error) {
+	if index == "" {
+		return s.PartitionKey, s.SortKey, nil
+	}
+
+	idx, ok := s.Indexes[index]
+	if !ok {
+		return nil, nil, fmt.Errorf("dynamo: index %s is not declared on %s", index, s.Type)
+	}
+
+	return idx.PartitionKey, idx.SortKey, nil
+}
+
+// ScalarType returns the DynamoDB scalar attribute type (S, N or B) of the field
+func (f *Field) ScalarType() string {
+	t := f.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "N"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "B"
+		}
+	}
+
+	return "S"
+}
+
+func (f *Field) value(v reflect.Value) reflect.Value {
+	return v.FieldByIndex(f.Index)
+}
+
+func assign(dst **Field, f *Field, role string) error {
+	if *dst != nil {
+		return fmt.Errorf("dynamo: %s declared on both %s and %s", role, (*dst).Name, f.Name)
+	}
+	*dst = f
+
+	return nil
+}
+
+// attributeName resolves the attribute name the same way dynamodbattribute does
+func attributeName(sf reflect.StructField) string {
+	for _, key := range []string{"dynamodbav", "json"} {
+		if name := strings.Split(sf.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+
+	return sf.Name
+}
+
+func setExpiry(f *Field, v reflect.Value, at time.Time) {
+	fv := f.value(v)
+	if !fv.CanSet() || !fv.IsZero() {
+		return
+	}
+
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		fv.SetInt(at.Unix())
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		fv.SetUint(uint64(at.Unix()))
+	default:
+		if fv.Type() == reflect.TypeOf(time.Time{}) {
+			fv.Set(reflect.ValueOf(at))
+		}
+	}
+}
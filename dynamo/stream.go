@@ -0,0 +1,270 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kraneware/kws/services"
+)
+
+// Stream event names
+const (
+	EventInsert = "INSERT"
+	EventModify = "MODIFY"
+	EventRemove = "REMOVE"
+)
+
+// StreamRecord is a stream record routed to a StreamHandler. Old and New hold pointers to the
+// registered model decoded from OldImage and NewImage, or nil when the image is absent (as for
+// the old image of an INSERT or with a KEYS_ONLY stream).
+type StreamRecord struct {
+	Table     string
+	EventName string
+	Keys      map[string]events.DynamoDBAttributeValue
+	Old       interface{}
+	New       interface{}
+	Record    *events.DynamoDBEventRecord
+
+	changes ChangeSet
+}
+
+// Changes returns the attribute changes between the old and new image of the record
+func (r *StreamRecord) Changes() ChangeSet {
+	if r.changes == nil {
+		r.changes = DiffRecord(*r.Record)
+	}
+
+	return r.changes
+}
+
+// StreamHandler processes a single stream record. Returning an error reports the record as a
+// batch item failure.
+type StreamHandler func(ctx context.Context, r *StreamRecord) error
+
+// StreamDispatcher routes the records of a DynamoDB stream event to typed handlers by table
+// and event name.
+//
+// Records sharing a partition key are processed one at a time in stream order; different
+// partition keys are processed concurrently (see the Concurrency option). When a record fails,
+// the records after it with the same partition key are skipped and reported as failures too,
+// so Lambda retries them in order. The function must have ReportBatchItemFailures enabled.
+//
+// Example:
+//
+//	d := dynamo.NewStreamDispatcher()
+//	_ = d.Handle("orders", order{}, onOrder, dynamo.EventInsert, dynamo.EventModify)
+//	lambda.Start(d.Dispatch)
+type StreamDispatcher struct {
+	routes      map[string][]*route
+	concurrency int
+}
+
+type route struct {
+	schema *Schema
+	events map[string]bool
+	paths  []string
+	fn     StreamHandler
+}
+
+// NewStreamDispatcher creates an empty dispatcher. Supported options are Concurrency.
+func NewStreamDispatcher(opts ...Option) *StreamDispatcher {
+	return &StreamDispatcher{
+		routes:      make(map[string][]*route),
+		concurrency: applyOptions(opts).concurrency,
+	}
+}
+
+// Handle registers fn for the records of table decoded into model. Without event names fn
+// receives INSERT, MODIFY and REMOVE records. Several handlers may match the same record; they
+// run in registration order and the first error stops the record.
+func (d *StreamDispatcher) Handle(table string, model interface{}, fn StreamHandler, eventNames ...string) error {
+	s, err := SchemaOf(model)
+	if err != nil {
+		return err
+	}
+
+	r := &route{schema: s, fn: fn}
+	if len(eventNames) > 0 {
+		r.events = make(map[string]bool, len(eventNames))
+		for _, e := range eventNames {
+			switch e {
+			case EventInsert, EventModify, EventRemove:
+				r.events[e] = true
+			default:
+				return fmt.Errorf("dynamo: unknown stream event %q", e)
+			}
+		}
+	}
+
+	d.routes[table] = append(d.routes[table], r)
+
+	return nil
+}
+
+// HandleChanges registers fn for the records of table whose images differ on one of the given
+// attribute paths (see ChangeSet.Touches). The stream must carry both images.
+func (d *StreamDispatcher) HandleChanges(table string, model interface{}, paths []string, fn StreamHandler, eventNames ...string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("dynamo: no paths to watch on %s", table)
+	}
+
+	if err := d.Handle(table, model, fn, eventNames...); err != nil {
+		return err
+	}
+
+	routes := d.routes[table]
+	routes[len(routes)-1].paths = paths
+
+	return nil
+}
+
+// Dispatch processes every record of the event and reports the failed ones by sequence number.
+// Records of tables or events without a handler are skipped.
+func (d *StreamDispatcher) Dispatch(ctx context.Context, e events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	var (
+		order  []string
+		groups = make(map[string][]int)
+	)
+
+	for i := range e.Records {
+		rec := &e.Records[i]
+		routes := d.routes[TableFromARN(rec.EventSourceArn)]
+		if len(routes) == 0 {
+			continue
+		}
+
+		g := groupKey(rec, routes[0].schema)
+		if _, ok := groups[g]; !ok {
+			order = append(order, g)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	failed := make([]bool, len(e.Records))
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, d.concurrency)
+	)
+	for _, g := range order {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(indexes []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			for n, i := range indexes {
+				if ctx.Err() != nil || d.process(ctx, &e.Records[i]) != nil {
+					for _, j := range indexes[n:] {
+						failed[j] = true
+					}
+					return
+				}
+			}
+		}(groups[g])
+	}
+	wg.Wait()
+
+	var resp events.DynamoDBEventResponse
+	for i, f := range failed {
+		if f {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.DynamoDBBatchItemFailure{
+				ItemIdentifier: e.Records[i].Change.SequenceNumber,
+			})
+		}
+	}
+
+	return resp, nil
+}
+
+func (d *StreamDispatcher) process(ctx context.Context, rec *events.DynamoDBEventRecord) error {
+	table := TableFromARN(rec.EventSourceArn)
+
+	var changes ChangeSet
+	for _, r := range d.routes[table] {
+		if r.events != nil && !r.events[rec.EventName] {
+			continue
+		}
+
+		if r.paths != nil {
+			if changes == nil {
+				changes = DiffRecord(*rec)
+			}
+			if !changes.Touches(r.paths...) {
+				continue
+			}
+		}
+
+		sr := &StreamRecord{
+			Table:     table,
+			EventName: rec.EventName,
+			Keys:      rec.Change.Keys,
+			Record:    rec,
+			changes:   changes,
+		}
+
+		var err error
+		sr.Old, err = decodeImage(rec.Change.OldImage, r.schema.Type)
+		if err == nil {
+			sr.New, err = decodeImage(rec.Change.NewImage, r.schema.Type)
+		}
+		if err == nil {
+			err = r.fn(ctx, sr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeImage(image map[string]events.DynamoDBAttributeValue, t reflect.Type) (interface{}, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+
+	v := reflect.New(t)
+	if err := services.UnmarshalStreamImage(image, v.Interface()); err != nil {
+		return nil, err
+	}
+
+	return v.Interface(), nil
+}
+
+// groupKey identifies the table and partition key of a record
+func groupKey(rec *events.DynamoDBEventRecord, s *Schema) string {
+	pk := ""
+	if av, ok := rec.Change.Keys[s.PartitionKey.Attribute]; ok && !av.IsNull() {
+		switch av.DataType() {
+		case events.DataTypeString:
+			pk = av.String()
+		case events.DataTypeNumber:
+			pk = av.Number()
+		case events.DataTypeBinary:
+			pk = string(av.Binary())
+		}
+	}
+
+	return rec.EventSourceArn + "\x00" + pk
+}
+
+// TableFromARN returns the table name of a table or stream ARN such as
+// arn:aws:dynamodb:us-east-1:123456789012:table/Orders/stream/2021-01-01T00:00:00.000
+func TableFromARN(arn string) string {
+	i := strings.Index(arn, ":table/")
+	if i < 0 {
+		return ""
+	}
+
+	name := arn[i+len(":table/"):]
+	if j := strings.Index(name, "/"); j >= 0 {
+		name = name[:j]
+	}
+
+	return name
+}
@@ -0,0 +1,119 @@
+package dynamo_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kraneware/kws/dynamo"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const ordersStreamARN = "arn:aws:dynamodb:us-east-1:123456789012:table/orders/stream/2021-01-01T00:00:00.000"
+
+func streamRecord(seq, name string, old, new interface{}) events.DynamoDBEventRecord {
+	rec := events.DynamoDBEventRecord{
+		EventName:      name,
+		EventSourceArn: ordersStreamARN,
+		Change:         events.DynamoDBStreamRecord{SequenceNumber: seq},
+	}
+
+	image := func(v interface{}) map[string]events.DynamoDBAttributeValue {
+		if v == nil {
+			return nil
+		}
+		m, err := services.MarshalStreamImage(v)
+		Expect(err).Should(BeNil())
+		rec.Change.Keys = map[string]events.DynamoDBAttributeValue{"customer": m["customer"], "id": m["id"]}
+		return m
+	}
+	rec.Change.OldImage = image(old)
+	rec.Change.NewImage = image(new)
+
+	return rec
+}
+
+var _ = Describe("StreamDispatcher", func() {
+	var (
+		ctx context.Context
+		d   *dynamo.StreamDispatcher
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		d = dynamo.NewStreamDispatcher()
+	})
+
+	It("should parse table names from ARNs", func() {
+		Expect(dynamo.TableFromARN(ordersStreamARN)).Should(Equal("orders"))
+		Expect(dynamo.TableFromARN("arn:aws:dynamodb:us-east-1:123456789012:table/orders")).Should(Equal("orders"))
+		Expect(dynamo.TableFromARN("arn:aws:sqs:us-east-1:123456789012:queue")).Should(Equal(""))
+	})
+
+	It("should route records to typed handlers by event", func() {
+		var (
+			inserts []*order
+			changes [][2]*order
+		)
+		Expect(d.Handle("orders", order{}, func(_ context.Context, r *dynamo.StreamRecord) error {
+			Expect(r.Old).Should(BeNil())
+			inserts = append(inserts, r.New.(*order))
+			return nil
+		}, dynamo.EventInsert)).Should(BeNil())
+		Expect(d.Handle("orders", order{}, func(_ context.Context, r *dynamo.StreamRecord) error {
+			changes = append(changes, [2]*order{r.Old.(*order), r.New.(*order)})
+			return nil
+		}, dynamo.EventModify)).Should(BeNil())
+		Expect(d.Handle("orders", order{}, nil, "UPSERT")).ShouldNot(BeNil())
+
+		o := order{Customer: "c1", ID: "o1", Status: "NEW"}
+		paid := order{Customer: "c1", ID: "o1", Status: "PAID"}
+		other := streamRecord("4", dynamo.EventInsert, nil, o)
+		other.EventSourceArn = "arn:aws:dynamodb:us-east-1:123456789012:table/other/stream/x"
+
+		resp, err := d.Dispatch(ctx, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+			streamRecord("1", dynamo.EventInsert, nil, o),
+			streamRecord("2", dynamo.EventModify, o, paid),
+			streamRecord("3", dynamo.EventRemove, paid, nil),
+			other,
+		}})
+		Expect(err).Should(BeNil())
+		Expect(resp.BatchItemFailures).Should(BeEmpty())
+		Expect(inserts).Should(Equal([]*order{&o}))
+		Expect(changes).Should(Equal([][2]*order{{&o, &paid}}))
+	})
+
+	It("should keep order per partition key and fail the rest of a failed group", func() {
+		var (
+			mu   sync.Mutex
+			seen = make(map[string][]string)
+		)
+		Expect(d.Handle("orders", order{}, func(_ context.Context, r *dynamo.StreamRecord) error {
+			o := r.New.(*order)
+			if o.Status == "BAD" {
+				return errors.New("boom")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			seen[o.Customer] = append(seen[o.Customer], o.ID)
+			return nil
+		})).Should(BeNil())
+
+		resp, err := d.Dispatch(ctx, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+			streamRecord("1", dynamo.EventInsert, nil, order{Customer: "c1", ID: "a"}),
+			streamRecord("2", dynamo.EventInsert, nil, order{Customer: "c2", ID: "a"}),
+			streamRecord("3", dynamo.EventInsert, nil, order{Customer: "c1", ID: "b", Status: "BAD"}),
+			streamRecord("4", dynamo.EventInsert, nil, order{Customer: "c2", ID: "b"}),
+			streamRecord("5", dynamo.EventInsert, nil, order{Customer: "c1", ID: "c"}),
+		}})
+		Expect(err).Should(BeNil())
+		Expect(seen).Should(Equal(map[string][]string{"c1": {"a"}, "c2": {"a", "b"}}))
+		Expect(resp.BatchItemFailures).Should(Equal([]events.DynamoDBBatchItemFailure{
+			{ItemIdentifier: "3"},
+			{ItemIdentifier: "5"},
+		}))
+	})
+})
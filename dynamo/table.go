@@ -0,0 +1,386 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/kraneware/kws/services"
+)
+
+// ErrNotFound is returned by Get when no item exists for the given key
+var ErrNotFound = errors.New("dynamo: item not found")
+
+// Table is a typed repository over a single DynamoDB table whose items are described by a
+// tagged model struct
+type Table struct {
+	Name   string
+	Schema *Schema
+
+	// Client defaults to services.DynamoDbClient() when nil
+	Client dynamodbiface.DynamoDBAPI
+
+	// DefaultTTL is applied to the ttl field on Put when the field is empty
+	DefaultTTL time.Duration
+}
+
+// NewTable creates a table repository for the given model (e.g. User{} or &User{})
+func NewTable(name string, model interface{}) (*Table, error) {
+	s, err := SchemaOf(model)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Table{Name: name, Schema: s}, nil
+}
+
+func (t *Table) client() dynamodbiface.DynamoDBAPI {
+	if t.Client != nil {
+		return t.Client
+	}
+
+	return services.DynamoDbClient()
+}
+
+// Key builds the primary key attribute map from the key fields of the given item
+func (t *Table) Key(item interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	v, err := t.structValue(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.keyOf(v)
+}
+
+func (t *Table) keyOf(v reflect.Value) (map[string]*dynamodb.AttributeValue, error) {
+	key := make(map[string]*dynamodb.AttributeValue, 2)
+
+	for _, f := range []*Field{t.Schema.PartitionKey, t.Schema.SortKey} {
+		if f == nil {
+			continue
+		}
+
+		av, err := dynamodbattribute.Marshal(f.value(v).Interface())
+		if err != nil {
+			return nil, err
+		}
+		if av.NULL != nil {
+			return nil, fmt.Errorf("dynamo: key field %s is empty", f.Name)
+		}
+		key[f.Attribute] = av
+	}
+
+	return key, nil
+}
+
+func (t *Table) structValue(item interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(item)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, fmt.Errorf("dynamo: nil %T", item)
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() != t.Schema.Type {
+		return v, fmt.Errorf("dynamo: table %s expects %s, got %T", t.Name, t.Schema.Type, item)
+	}
+
+	return v, nil
+}
+
+func (t *Table) pointerValue(item interface{}) (reflect.Value, error) {
+	v, err := t.structValue(item)
+	if err == nil && !v.CanSet() {
+		err = fmt.Errorf("dynamo: %T must be a pointer", item)
+	}
+
+	return v, err
+}
+
+// Get loads the item whose key fields are set on item (a pointer to the model) into item
+func (t *Table) Get(ctx aws.Context, item interface{}, opts ...Option) error {
+	v, err := t.pointerValue(item)
+	if err != nil {
+		return err
+	}
+
+	key, err := t.keyOf(v)
+	if err != nil {
+		return err
+	}
+
+	o := applyOptions(opts)
+	out, err := t.client().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(t.Name),
+		Key:            key,
+		ConsistentRead: aws.Bool(o.consistent),
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(out.Item) == 0 {
+		return ErrNotFound
+	}
+
+	v.Set(reflect.Zero(v.Type()))
+
+	return dynamodbattribute.UnmarshalMap(out.Item, item)
+}
+
+// write is a single Put, Update, Delete or condition check prepared from a model
+type write struct {
+	key  map[string]*dynamodb.AttributeValue
+	item map[string]*dynamodb.AttributeValue
+	expr expression.Expression
+	lock *versionLock
+	v    reflect.Value
+
+	// notExists is set when the only condition is that the key is not taken
+	notExists bool
+}
+
+func buildExpression(update *expression.UpdateBuilder, cond *expression.ConditionBuilder) (expression.Expression, error) {
+	if update == nil && cond == nil {
+		return expression.Expression{}, nil
+	}
+
+	b := expression.NewBuilder()
+	if update != nil {
+		b = b.WithUpdate(*update)
+	}
+	if cond != nil {
+		b = b.WithCondition(*cond)
+	}
+
+	return b.Build()
+}
+
+func (t *Table) preparePut(item interface{}, opts []Option) (*write, error) {
+	v, err := t.structValue(item)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &write{v: v}
+	if w.key, err = t.keyOf(v); err != nil {
+		return nil, err
+	}
+
+	if w.lock, err = t.lockVersion(v, true); err != nil {
+		return nil, err
+	}
+
+	o := applyOptions(opts)
+	cond := w.lock.condition()
+	if o.notExists {
+		// a new item has no version to check
+		c := expression.Name(t.Schema.PartitionKey.Attribute).AttributeNotExists()
+		cond = &c
+		w.notExists = o.condition == nil
+	}
+
+	w.item, err = t.marshalItem(v)
+	if err == nil {
+		w.expr, err = buildExpression(nil, combine(o.condition, cond))
+	}
+
+	if err != nil {
+		w.lock.rollback()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (t *Table) prepareDelete(item interface{}, opts []Option) (*write, error) {
+	v, err := t.structValue(item)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &write{v: v}
+	if w.key, err = t.keyOf(v); err != nil {
+		return nil, err
+	}
+
+	if w.lock, err = t.lockVersion(v, false); err != nil {
+		return nil, err
+	}
+
+	w.expr, err = buildExpression(nil, combine(applyOptions(opts).condition, w.lock.condition()))
+
+	return w, err
+}
+
+func (t *Table) prepareUpdate(item interface{}, update expression.UpdateBuilder, opts []Option) (*write, error) {
+	w, err := t.prepareDelete(item, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.lock != nil {
+		update = update.Set(expression.Name(w.lock.field.Attribute), expression.Value(w.lock.old+1))
+	}
+
+	w.expr, err = buildExpression(&update, combine(applyOptions(opts).condition, w.lock.condition()))
+
+	return w, err
+}
+
+// Put writes the item, replacing any existing item with the same key. Items with a version
+// field must be passed by pointer; the version is checked and incremented in place.
+func (t *Table) Put(ctx aws.Context, item interface{}, opts ...Option) error {
+	w, err := t.preparePut(item, opts)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.client().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(t.Name),
+		Item:                      w.item,
+		ConditionExpression:       w.expr.Condition(),
+		ExpressionAttributeNames:  w.expr.Names(),
+		ExpressionAttributeValues: w.expr.Values(),
+	})
+	if err != nil {
+		w.lock.rollback()
+	}
+
+	return t.conditionError(err, w)
+}
+
+func (t *Table) marshalItem(v reflect.Value) (map[string]*dynamodb.AttributeValue, error) {
+	if t.Schema.TTL != nil && t.DefaultTTL > 0 {
+		if !v.CanSet() {
+			c := reflect.New(v.Type()).Elem()
+			c.Set(v)
+			v = c
+		}
+		setExpiry(t.Schema.TTL, v, time.Now().Add(t.DefaultTTL))
+	}
+
+	return dynamodbattribute.MarshalMap(v.Interface())
+}
+
+// Delete removes the item identified by the key fields of item. For versioned items the
+// delete only succeeds if the stored version matches.
+func (t *Table) Delete(ctx aws.Context, item interface{}, opts ...Option) error {
+	w, err := t.prepareDelete(item, opts)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.client().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(t.Name),
+		Key:                       w.key,
+		ConditionExpression:       w.expr.Condition(),
+		ExpressionAttributeNames:  w.expr.Names(),
+		ExpressionAttributeValues: w.expr.Values(),
+	})
+
+	return t.conditionError(err, w)
+}
+
+// Update applies the update expression to the item identified by the key fields of item and
+// loads the updated item back into item (a pointer to the model). For versioned items the
+// stored version is checked and incremented as part of the same write.
+func (t *Table) Update(ctx aws.Context, item interface{}, update expression.UpdateBuilder, opts ...Option) error {
+	v, err := t.pointerValue(item)
+	if err != nil {
+		return err
+	}
+
+	w, err := t.prepareUpdate(item, update, opts)
+	if err != nil {
+		return err
+	}
+
+	out, err := t.client().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.Name),
+		Key:                       w.key,
+		UpdateExpression:          w.expr.Update(),
+		ConditionExpression:       w.expr.Condition(),
+		ExpressionAttributeNames:  w.expr.Names(),
+		ExpressionAttributeValues: w.expr.Values(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err != nil {
+		return t.conditionError(err, w)
+	}
+
+	v.Set(reflect.Zero(v.Type()))
+
+	return dynamodbattribute.UnmarshalMap(out.Attributes, item)
+}
+
+// Query runs the key condition against the table (or the index given with the Index option)
+// following every page, and decodes the results into out (a pointer to a slice of the model)
+func (t *Table) Query(ctx aws.Context, cond expression.KeyConditionBuilder, out interface{}, opts ...Option) error {
+	o := applyOptions(opts)
+	if _, _, err := t.Schema.Keys(o.index); err != nil {
+		return err
+	}
+
+	b := expression.NewBuilder().WithKeyCondition(cond)
+	if o.filter != nil {
+		b = b.WithFilter(*o.filter)
+	}
+
+	expr, err := b.Build()
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(t.Name),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConsistentRead:            aws.Bool(o.consistent),
+		ScanIndexForward:          aws.Bool(!o.descending),
+	}
+	if o.index != "" {
+		input.IndexName = aws.String(o.index)
+	}
+
+	return Query(ctx, input, append(opts, WithClient(t.client()))...).All(out)
+}
+
+// Scan reads the whole table (or the index given with the Index option) following every page,
+// and decodes the results into out (a pointer to a slice of the model). With the Segments
+// option the scan runs in parallel.
+func (t *Table) Scan(ctx aws.Context, out interface{}, opts ...Option) error {
+	o := applyOptions(opts)
+	if _, _, err := t.Schema.Keys(o.index); err != nil {
+		return err
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(t.Name),
+		ConsistentRead: aws.Bool(o.consistent),
+	}
+	if o.index != "" {
+		input.IndexName = aws.String(o.index)
+	}
+
+	if o.filter != nil {
+		expr, err := expression.NewBuilder().WithFilter(*o.filter).Build()
+		if err != nil {
+			return err
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+
+	return Scan(ctx, input, append(opts, WithClient(t.client()))...).All(out)
+}
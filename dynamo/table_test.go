@@ -0,0 +1,237 @@
+package dynamo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type order struct {
+	Customer string `json:"customer" dynamo:"pk"`
+	ID       string `json:"id" dynamo:"sk"`
+	Status   string `json:"status" dynamo:"gsi=ByStatus"`
+	Total    int    `json:"total" dynamo:"gsi=ByStatus:sk,lsi=ByTotal"`
+	Expires  int64  `json:"expires,omitempty" dynamo:"ttl"`
+}
+
+type account struct {
+	ID      string `json:"id" dynamo:"pk"`
+	Balance int    `json:"balance"`
+	Version int64  `json:"version" dynamo:"version"`
+}
+
+var _ = Describe("Table", func() {
+	var (
+		ctx   context.Context
+		fake  *fakeDynamo
+		table *dynamo.Table
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		fake = newFakeDynamo("customer", "id")
+		table, err = dynamo.NewTable("orders", order{})
+		Expect(err).Should(BeNil())
+		table.Client = fake
+	})
+
+	Context("Schema", func() {
+		It("should parse keys, indexes and ttl from tags", func() {
+			s := table.Schema
+			Expect(s.PartitionKey.Attribute).Should(Equal("customer"))
+			Expect(s.SortKey.Attribute).Should(Equal("id"))
+			Expect(s.TTL.Attribute).Should(Equal("expires"))
+			Expect(s.Indexes["ByStatus"].PartitionKey.Attribute).Should(Equal("status"))
+			Expect(s.Indexes["ByStatus"].SortKey.Attribute).Should(Equal("total"))
+			Expect(s.Indexes["ByTotal"].Local).Should(BeTrue())
+			Expect(s.Indexes["ByTotal"].PartitionKey).Should(Equal(s.PartitionKey))
+			Expect(s.Indexes["ByStatus"].SortKey.ScalarType()).Should(Equal("N"))
+		})
+
+		It("should reject models without a partition key", func() {
+			_, err := dynamo.SchemaOf(struct{ A string }{})
+			Expect(err).ShouldNot(BeNil())
+
+			_, err = dynamo.SchemaOf(struct {
+				A string `dynamo:"pk"`
+				B string `dynamo:"pk"`
+			}{})
+			Expect(err).ShouldNot(BeNil())
+
+			_, err = dynamo.SchemaOf("not a struct")
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("Item operations", func() {
+		It("should put, get and delete typed items", func() {
+			o := order{Customer: "c1", ID: "o1", Status: "NEW", Total: 10}
+			Expect(table.Put(ctx, o)).Should(BeNil())
+
+			got := order{Customer: "c1", ID: "o1"}
+			Expect(table.Get(ctx, &got, dynamo.ConsistentRead())).Should(BeNil())
+			Expect(got).Should(Equal(o))
+
+			Expect(table.Delete(ctx, &got)).Should(BeNil())
+			Expect(table.Get(ctx, &got)).Should(Equal(dynamo.ErrNotFound))
+		})
+
+		It("should require key fields and pointers", func() {
+			Expect(table.Put(ctx, order{ID: "o1"})).ShouldNot(BeNil())
+			Expect(table.Get(ctx, order{Customer: "c1", ID: "o1"})).ShouldNot(BeNil())
+			Expect(table.Put(ctx, struct{}{})).ShouldNot(BeNil())
+		})
+
+		It("should fill the ttl field from DefaultTTL", func() {
+			table.DefaultTTL = time.Hour
+			now := time.Now().Unix()
+			Expect(table.Put(ctx, order{Customer: "c1", ID: "o1"})).Should(BeNil())
+
+			expires, err := strconv.ParseInt(aws.StringValue(fake.lastPut.Item["expires"].N), 10, 64)
+			Expect(err).Should(BeNil())
+			Expect(expires).Should(BeNumerically("~", now+3600, 1))
+		})
+
+		It("should update and return the new item", func() {
+			Expect(table.Put(ctx, order{Customer: "c1", ID: "o1", Status: "NEW"})).Should(BeNil())
+
+			o := order{Customer: "c1", ID: "o1"}
+			Expect(table.Update(ctx, &o, expression.Set(expression.Name("status"), expression.Value("PAID")))).Should(BeNil())
+			Expect(*fake.lastUpdate.UpdateExpression).Should(ContainSubstring("SET"))
+			Expect(o.Status).Should(Equal("NEW"))
+		})
+	})
+
+	Context("Query and Scan", func() {
+		BeforeEach(func() {
+			fake.pageSize = 2
+			for i := 0; i < 5; i++ {
+				Expect(table.Put(ctx, order{Customer: "c1", ID: fmt.Sprintf("o%d", i), Total: i})).Should(BeNil())
+			}
+		})
+
+		It("should follow pages", func() {
+			var out []order
+			cond := expression.Key("customer").Equal(expression.Value("c1"))
+			Expect(table.Query(ctx, cond, &out)).Should(BeNil())
+			Expect(out).Should(HaveLen(5))
+			Expect(fake.calls["Query"]).Should(Equal(3))
+
+			var all []*order
+			Expect(table.Scan(ctx, &all)).Should(BeNil())
+			Expect(all).Should(HaveLen(5))
+			Expect(all[4].Total).Should(Equal(4))
+		})
+
+		It("should honour limits, indexes and filters", func() {
+			var out []order
+			cond := expression.Key("status").Equal(expression.Value("NEW"))
+			Expect(table.Query(ctx, cond, &out,
+				dynamo.Index("ByStatus"),
+				dynamo.Limit(3),
+				dynamo.Descending(),
+				dynamo.Filter(expression.Name("total").GreaterThan(expression.Value(0))),
+			)).Should(BeNil())
+			Expect(out).Should(HaveLen(3))
+			Expect(*fake.lastQuery.IndexName).Should(Equal("ByStatus"))
+			Expect(*fake.lastQuery.ScanIndexForward).Should(BeFalse())
+			Expect(fake.lastQuery.FilterExpression).ShouldNot(BeNil())
+
+			Expect(table.Query(ctx, cond, &out, dynamo.Index("Missing"))).ShouldNot(BeNil())
+			Expect(table.Scan(ctx, &out, dynamo.Limit(1))).Should(BeNil())
+			Expect(out).Should(HaveLen(1))
+		})
+	})
+
+	Context("Conditional writes", func() {
+		var accounts *dynamo.Table
+
+		BeforeEach(func() {
+			var err error
+			accounts, err = dynamo.NewTable("accounts", &account{})
+			Expect(err).Should(BeNil())
+			accounts.Client = fake
+			fake.keys = []string{"id"}
+		})
+
+		It("should check and increment the version on put", func() {
+			a := account{ID: "a1", Balance: 5}
+			Expect(accounts.Put(ctx, &a)).Should(BeNil())
+			Expect(a.Version).Should(Equal(int64(1)))
+			Expect(*fake.lastPut.ConditionExpression).Should(Equal("attribute_not_exists (#0)"))
+
+			Expect(accounts.Put(ctx, &a)).Should(BeNil())
+			Expect(a.Version).Should(Equal(int64(2)))
+			Expect(*fake.lastPut.ConditionExpression).Should(Equal("#0 = :0"))
+			Expect(*fake.lastPut.ExpressionAttributeValues[":0"].N).Should(Equal("1"))
+
+			Expect(accounts.Put(ctx, a)).ShouldNot(BeNil())
+		})
+
+		It("should report a version conflict and roll back the version", func() {
+			a := account{ID: "a1", Version: 3}
+			fake.failConditions = 1
+
+			err := accounts.Put(ctx, &a)
+			Expect(errors.Is(err, dynamo.ErrVersionConflict)).Should(BeTrue())
+			Expect(errors.Is(err, dynamo.ErrConditionFailed)).Should(BeTrue())
+			Expect(dynamo.IsConditionalCheckFailed(err)).Should(BeTrue())
+			Expect(a.Version).Should(Equal(int64(3)))
+
+			var ce *dynamo.ConditionError
+			Expect(errors.As(err, &ce)).Should(BeTrue())
+			Expect(ce.Version).Should(Equal(int64(3)))
+		})
+
+		It("should bump the version on update and check it on delete", func() {
+			a := account{ID: "a1"}
+			Expect(accounts.Put(ctx, &a)).Should(BeNil())
+			Expect(accounts.Update(ctx, &a, expression.Set(expression.Name("balance"), expression.Value(9)))).Should(BeNil())
+			Expect(*fake.lastUpdate.UpdateExpression).Should(ContainSubstring(","))
+			Expect(fake.lastUpdate.ConditionExpression).ShouldNot(BeNil())
+
+			fake.failConditions = 1
+			Expect(errors.Is(accounts.Delete(ctx, &a), dynamo.ErrVersionConflict)).Should(BeTrue())
+			Expect(*fake.lastDelete.ConditionExpression).Should(Equal("#0 = :0"))
+		})
+
+		It("should put only when missing and delete only when matching", func() {
+			Expect(table.PutIfNotExists(ctx, order{Customer: "c1", ID: "o1"})).Should(BeNil())
+			Expect(*fake.lastPut.ConditionExpression).Should(ContainSubstring("attribute_not_exists"))
+
+			fake.failConditions = 1
+			err := table.PutIfNotExists(ctx, order{Customer: "c1", ID: "o1"})
+			Expect(errors.Is(err, dynamo.ErrConditionFailed)).Should(BeTrue())
+			Expect(errors.Is(err, dynamo.ErrVersionConflict)).Should(BeFalse())
+
+			Expect(errors.Is(err, dynamo.ErrItemExists)).Should(BeTrue())
+
+			// a versioned clash is not a version conflict
+			a := account{ID: "a1", Version: 2}
+			Expect(accounts.PutIfNotExists(ctx, &a)).Should(BeNil())
+			Expect(*fake.lastPut.ConditionExpression).Should(Equal("attribute_not_exists (#0)"))
+			Expect(a.Version).Should(Equal(int64(3)))
+
+			fake.failConditions = 1
+			err = accounts.PutIfNotExists(ctx, &a)
+			Expect(errors.Is(err, dynamo.ErrItemExists)).Should(BeTrue())
+			Expect(errors.Is(err, dynamo.ErrVersionConflict)).Should(BeFalse())
+			Expect(a.Version).Should(Equal(int64(3)))
+
+			fake.failConditions = 1
+			cond := expression.Name("status").Equal(expression.Value("NEW"))
+			err = table.DeleteIfMatches(ctx, order{Customer: "c1", ID: "o1"}, cond)
+			Expect(errors.Is(err, dynamo.ErrConditionFailed)).Should(BeTrue())
+			Expect(table.DeleteIfMatches(ctx, order{Customer: "c1", ID: "o1"}, cond)).Should(BeNil())
+		})
+	})
+})
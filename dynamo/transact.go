@@ -0,0 +1,354 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/google/uuid"
+)
+
+// MaxTransactItems is the number of operations allowed in a single transaction
+const MaxTransactItems = 100
+
+// ErrTooManyTransactItems is returned when a transaction holds more than MaxTransactItems
+var ErrTooManyTransactItems = fmt.Errorf("dynamo: a transaction is limited to %d items", MaxTransactItems)
+
+// TxItemError is the cancellation reason of a single item of a canceled transaction
+type TxItemError struct {
+	Index   int
+	Table   string
+	Key     map[string]*dynamodb.AttributeValue
+	Code    string
+	Message string
+
+	// Item is the current item of a failed condition, as every write of a WriteTx asks for
+	// ALL_OLD values on condition failures
+	Item map[string]*dynamodb.AttributeValue
+
+	versioned bool
+}
+
+func (e *TxItemError) Error() string {
+	return fmt.Sprintf("item %d on table %s: %s %s", e.Index, e.Table, e.Code, e.Message)
+}
+
+// Is reports whether the item failed its condition (ErrConditionFailed) or its version check
+// (ErrVersionConflict)
+func (e *TxItemError) Is(target error) bool {
+	failed := e.Code == "ConditionalCheckFailed"
+
+	return (failed && target == ErrConditionFailed) || (failed && e.versioned && target == ErrVersionConflict)
+}
+
+// TxCanceledError is returned when DynamoDB cancels a transaction. Reasons only holds the items
+// that caused the cancellation.
+type TxCanceledError struct {
+	Reasons []*TxItemError
+	Err     error
+}
+
+func (e *TxCanceledError) Error() string {
+	msgs := make([]string, 0, len(e.Reasons))
+	for _, r := range e.Reasons {
+		msgs = append(msgs, r.Error())
+	}
+
+	return "dynamo: transaction canceled: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether any item of the transaction matches target
+func (e *TxCanceledError) Is(target error) bool {
+	for _, r := range e.Reasons {
+		if r.Is(target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unwrap returns the underlying TransactionCanceledException
+func (e *TxCanceledError) Unwrap() error {
+	return e.Err
+}
+
+// WriteTx builds a TransactWriteItems request mixing Put, Update, Delete and ConditionCheck
+// operations across tables. Errors in the builder are reported by Run.
+//
+// Example:
+//
+//	err := dynamo.NewWriteTx().
+//		Put(orders, &order).
+//		Update(accounts, &account, expression.Add(expression.Name("total"), expression.Value(1))).
+//		Check(customers, &customer, expression.AttributeExists(expression.Name("id"))).
+//		Run(ctx)
+type WriteTx struct {
+	items  []*dynamodb.TransactWriteItem
+	tables []*Table
+	writes []*write
+	token  string
+	err    error
+}
+
+// NewWriteTx starts a write transaction with a random idempotency token. Running the same
+// WriteTx again within ten minutes is a no-op on the DynamoDB side.
+func NewWriteTx() *WriteTx {
+	return &WriteTx{token: uuid.New().String()}
+}
+
+// Token replaces the idempotency token (ClientRequestToken) of the transaction
+func (tx *WriteTx) Token(token string) *WriteTx {
+	tx.token = token
+	return tx
+}
+
+func (tx *WriteTx) add(t *Table, w *write, err error, item *dynamodb.TransactWriteItem) *WriteTx {
+	if err == nil && len(tx.items) >= MaxTransactItems {
+		err = ErrTooManyTransactItems
+	}
+
+	if tx.err != nil || err != nil {
+		if w != nil {
+			w.lock.rollback()
+		}
+		if tx.err == nil {
+			// the transaction will not run: undo the versions bumped by earlier operations
+			for _, earlier := range tx.writes {
+				earlier.lock.rollback()
+			}
+			tx.err = err
+		}
+		return tx
+	}
+
+	tx.items = append(tx.items, item)
+	tx.tables = append(tx.tables, t)
+	tx.writes = append(tx.writes, w)
+
+	return tx
+}
+
+// Put adds a put of item to the transaction. Versioned items are checked and incremented.
+func (tx *WriteTx) Put(t *Table, item interface{}, opts ...Option) *WriteTx {
+	w, err := t.preparePut(item, opts)
+	if err != nil {
+		return tx.add(t, nil, err, nil)
+	}
+
+	return tx.add(t, w, nil, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		TableName:                 aws.String(t.Name),
+		Item:                      w.item,
+		ConditionExpression:       w.expr.Condition(),
+		ExpressionAttributeNames:  w.expr.Names(),
+		ExpressionAttributeValues: w.expr.Values(),
+
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}})
+}
+
+// Update adds an update of the item identified by the key fields of item to the transaction
+func (tx *WriteTx) Update(t *Table, item interface{}, update expression.UpdateBuilder, opts ...Option) *WriteTx {
+	w, err := t.prepareUpdate(item, update, opts)
+	if err != nil {
+		return tx.add(t, nil, err, nil)
+	}
+
+	return tx.add(t, w, nil, &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+		TableName:                 aws.String(t.Name),
+		Key:                       w.key,
+		UpdateExpression:          w.expr.Update(),
+		ConditionExpression:       w.expr.Condition(),
+		ExpressionAttributeNames:  w.expr.Names(),
+		ExpressionAttributeValues: w.expr.Values(),
+
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}})
+}
+
+// Delete adds a delete of the item identified by the key fields of item to the transaction
+func (tx *WriteTx) Delete(t *Table, item interface{}, opts ...Option) *WriteTx {
+	w, err := t.prepareDelete(item, opts)
+	if err != nil {
+		return tx.add(t, nil, err, nil)
+	}
+
+	return tx.add(t, w, nil, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+		TableName:                 aws.String(t.Name),
+		Key:                       w.key,
+		ConditionExpression:       w.expr.Condition(),
+		ExpressionAttributeNames:  w.expr.Names(),
+		ExpressionAttributeValues: w.expr.Values(),
+
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}})
+}
+
+// Check adds a condition check on the item identified by the key fields of item
+func (tx *WriteTx) Check(t *Table, item interface{}, cond expression.ConditionBuilder) *WriteTx {
+	w := &write{}
+	v, err := t.structValue(item)
+	if err == nil {
+		w.key, err = t.keyOf(v)
+	}
+	if err == nil {
+		w.expr, err = buildExpression(nil, &cond)
+	}
+	if err != nil {
+		return tx.add(t, nil, err, nil)
+	}
+
+	return tx.add(t, w, nil, &dynamodb.TransactWriteItem{ConditionCheck: &dynamodb.ConditionCheck{
+		TableName:                 aws.String(t.Name),
+		Key:                       w.key,
+		ConditionExpression:       w.expr.Condition(),
+		ExpressionAttributeNames:  w.expr.Names(),
+		ExpressionAttributeValues: w.expr.Values(),
+
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}})
+}
+
+// Len returns the number of operations in the transaction
+func (tx *WriteTx) Len() int {
+	return len(tx.items)
+}
+
+// Run executes the transaction. A cancellation is returned as a *TxCanceledError. The versions
+// bumped by Put and Update are rolled back whenever the transaction does not succeed, including
+// when a builder call failed.
+func (tx *WriteTx) Run(ctx aws.Context) error {
+	if tx.err != nil || len(tx.items) == 0 {
+		return tx.err
+	}
+
+	_, err := tx.tables[0].client().TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems:      tx.items,
+		ClientRequestToken: aws.String(tx.token),
+	})
+	if err == nil {
+		return nil
+	}
+
+	for _, w := range tx.writes {
+		w.lock.rollback()
+	}
+
+	return decodeCancellation(err, len(tx.items), func(i int) (*Table, *write) {
+		return tx.tables[i], tx.writes[i]
+	})
+}
+
+// GetTx builds a TransactGetItems request across tables
+type GetTx struct {
+	items  []*dynamodb.TransactGetItem
+	tables []*Table
+	outs   []reflect.Value
+	err    error
+}
+
+// NewGetTx starts a read transaction
+func NewGetTx() *GetTx {
+	return &GetTx{}
+}
+
+// Get adds a read of the item identified by the key fields of item (a pointer to the model)
+func (tx *GetTx) Get(t *Table, item interface{}) *GetTx {
+	if tx.err != nil {
+		return tx
+	}
+
+	v, err := t.pointerValue(item)
+	var key map[string]*dynamodb.AttributeValue
+	if err == nil {
+		key, err = t.keyOf(v)
+	}
+	if err == nil && len(tx.items) >= MaxTransactItems {
+		err = ErrTooManyTransactItems
+	}
+
+	if err != nil {
+		tx.err = err
+		return tx
+	}
+
+	tx.items = append(tx.items, &dynamodb.TransactGetItem{Get: &dynamodb.Get{
+		TableName: aws.String(t.Name),
+		Key:       key,
+	}})
+	tx.tables = append(tx.tables, t)
+	tx.outs = append(tx.outs, v)
+
+	return tx
+}
+
+// Run executes the read transaction, loading every found item in place. The returned slice
+// reports, in order, whether each item exists.
+func (tx *GetTx) Run(ctx aws.Context) ([]bool, error) {
+	if tx.err != nil || len(tx.items) == 0 {
+		return nil, tx.err
+	}
+
+	out, err := tx.tables[0].client().TransactGetItemsWithContext(ctx, &dynamodb.TransactGetItemsInput{
+		TransactItems: tx.items,
+	})
+	if err != nil {
+		return nil, decodeCancellation(err, len(tx.items), func(i int) (*Table, *write) {
+			return tx.tables[i], &write{key: tx.items[i].Get.Key}
+		})
+	}
+
+	found := make([]bool, len(tx.outs))
+	for i, r := range out.Responses {
+		if i >= len(tx.outs) || r == nil || len(r.Item) == 0 {
+			continue
+		}
+
+		v := tx.outs[i]
+		v.Set(reflect.Zero(v.Type()))
+		if err = dynamodbattribute.UnmarshalMap(r.Item, v.Addr().Interface()); err != nil {
+			return found, err
+		}
+		found[i] = true
+	}
+
+	return found, nil
+}
+
+// decodeCancellation turns a TransactionCanceledException into a *TxCanceledError
+func decodeCancellation(err error, n int, lookup func(i int) (*Table, *write)) error {
+	var tce *dynamodb.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		return err
+	}
+
+	out := &TxCanceledError{Err: err}
+	for i, r := range tce.CancellationReasons {
+		code := aws.StringValue(r.Code)
+		if code == "" || code == "None" {
+			continue
+		}
+
+		if i >= n {
+			break
+		}
+
+		t, w := lookup(i)
+		out.Reasons = append(out.Reasons, &TxItemError{
+			Index:     i,
+			Table:     t.Name,
+			Key:       w.key,
+			Code:      code,
+			Message:   aws.StringValue(r.Message),
+			Item:      r.Item,
+			versioned: w.lock != nil,
+		})
+	}
+
+	return out
+}
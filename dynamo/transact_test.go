@@ -0,0 +1,132 @@
+package dynamo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/kraneware/kws/dynamo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transactions", func() {
+	var (
+		ctx      context.Context
+		fake     *fakeDynamo
+		orders   *dynamo.Table
+		accounts *dynamo.Table
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		fake = newFakeDynamo("customer", "id")
+
+		orders, err = dynamo.NewTable("orders", order{})
+		Expect(err).Should(BeNil())
+		orders.Client = fake
+
+		accounts, err = dynamo.NewTable("accounts", account{})
+		Expect(err).Should(BeNil())
+		accounts.Client = fake
+	})
+
+	It("should mix operations across tables with an idempotency token", func() {
+		a := account{ID: "a1", Version: 1}
+		err := dynamo.NewWriteTx().
+			Token("token-1").
+			Put(orders, order{Customer: "c1", ID: "o1"}).
+			Update(accounts, &a, expression.Add(expression.Name("balance"), expression.Value(5))).
+			Delete(orders, order{Customer: "c1", ID: "o0"}).
+			Check(accounts, account{ID: "a2"}, expression.AttributeExists(expression.Name("id"))).
+			Run(ctx)
+		Expect(err).Should(BeNil())
+
+		in := fake.lastTx
+		Expect(*in.ClientRequestToken).Should(Equal("token-1"))
+		Expect(in.TransactItems).Should(HaveLen(4))
+		Expect(*in.TransactItems[0].Put.TableName).Should(Equal("orders"))
+		Expect(*in.TransactItems[1].Update.ConditionExpression).Should(Equal("#0 = :0"))
+		Expect(in.TransactItems[2].Delete.ConditionExpression).Should(BeNil())
+		Expect(*in.TransactItems[3].ConditionCheck.TableName).Should(Equal("accounts"))
+
+		allOld := aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld)
+		Expect(in.TransactItems[0].Put.ReturnValuesOnConditionCheckFailure).Should(Equal(allOld))
+		Expect(in.TransactItems[1].Update.ReturnValuesOnConditionCheckFailure).Should(Equal(allOld))
+		Expect(in.TransactItems[2].Delete.ReturnValuesOnConditionCheckFailure).Should(Equal(allOld))
+		Expect(in.TransactItems[3].ConditionCheck.ReturnValuesOnConditionCheckFailure).Should(Equal(allOld))
+
+		Expect(dynamo.NewWriteTx().Put(orders, order{Customer: "c1", ID: "o1"}).Token("x").Token("y").Len()).Should(Equal(1))
+	})
+
+	It("should enforce the item limit", func() {
+		tx := dynamo.NewWriteTx()
+		for i := 0; i <= dynamo.MaxTransactItems; i++ {
+			tx.Put(orders, order{Customer: "c1", ID: fmt.Sprintf("o%d", i)})
+		}
+		Expect(tx.Len()).Should(Equal(dynamo.MaxTransactItems))
+		Expect(tx.Run(ctx)).Should(Equal(dynamo.ErrTooManyTransactItems))
+		Expect(fake.calls["TransactWriteItems"]).Should(Equal(0))
+	})
+
+	It("should roll back versions when a builder call fails", func() {
+		a := account{ID: "a1", Version: 2}
+		b := account{ID: "a2", Version: 7}
+		err := dynamo.NewWriteTx().
+			Put(accounts, &a).
+			Update(accounts, &b, expression.Add(expression.Name("balance"), expression.Value(1))).
+			Put(orders, order{ID: "missing customer"}).
+			Run(ctx)
+		Expect(err).ShouldNot(BeNil())
+		Expect(a.Version).Should(Equal(int64(2)))
+		Expect(b.Version).Should(Equal(int64(7)))
+		Expect(fake.calls["TransactWriteItems"]).Should(Equal(0))
+	})
+
+	It("should decode cancellation reasons into typed item errors", func() {
+		fake.cancelReasons = []*dynamodb.CancellationReason{
+			{Code: aws.String("None")},
+			{
+				Code:    aws.String("ConditionalCheckFailed"),
+				Message: aws.String("The conditional request failed"),
+				Item:    map[string]*dynamodb.AttributeValue{"id": {S: aws.String("a1")}, "version": {N: aws.String("5")}},
+			},
+		}
+
+		a := account{ID: "a1", Version: 4}
+		err := dynamo.NewWriteTx().
+			Put(orders, order{Customer: "c1", ID: "o1"}).
+			Put(accounts, &a).
+			Run(ctx)
+
+		var tce *dynamo.TxCanceledError
+		Expect(errors.As(err, &tce)).Should(BeTrue())
+		Expect(tce.Reasons).Should(HaveLen(1))
+		Expect(tce.Reasons[0].Index).Should(Equal(1))
+		Expect(tce.Reasons[0].Table).Should(Equal("accounts"))
+		Expect(tce.Reasons[0].Key).Should(HaveKey("id"))
+		Expect(*tce.Reasons[0].Item["version"].N).Should(Equal("5"))
+		Expect(errors.Is(err, dynamo.ErrConditionFailed)).Should(BeTrue())
+		Expect(errors.Is(err, dynamo.ErrVersionConflict)).Should(BeTrue())
+		Expect(err.Error()).Should(ContainSubstring("ConditionalCheckFailed"))
+		Expect(a.Version).Should(Equal(int64(4)))
+	})
+
+	It("should read items in a transaction", func() {
+		Expect(orders.Put(ctx, order{Customer: "c1", ID: "o1", Status: "NEW"})).Should(BeNil())
+
+		o1 := order{Customer: "c1", ID: "o1"}
+		o2 := order{Customer: "c1", ID: "o2"}
+		found, err := dynamo.NewGetTx().Get(orders, &o1).Get(orders, &o2).Run(ctx)
+		Expect(err).Should(BeNil())
+		Expect(found).Should(Equal([]bool{true, false}))
+		Expect(o1.Status).Should(Equal("NEW"))
+
+		_, err = dynamo.NewGetTx().Get(orders, o1).Run(ctx)
+		Expect(err).ShouldNot(BeNil())
+	})
+})
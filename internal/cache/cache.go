@@ -0,0 +1,139 @@
+// Package cache holds the in-process building blocks shared by the dynamo and services caches:
+// a size-bounded LRU and suppression of duplicate concurrent loads.
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a concurrency-safe least recently used cache holding at most Max entries
+type LRU struct {
+	max   int
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key   string
+	value interface{}
+}
+
+// NewLRU creates a cache evicting its least recently used entry beyond max entries. A max of
+// zero or less means unbounded.
+func NewLRU(max int) *LRU {
+	return &LRU{max: max, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get returns the value stored for key and marks it as recently used
+func (c *LRU) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+
+	return e.Value.(*entry).value, true
+}
+
+// Add stores value for key and returns whether an entry was evicted to make room
+func (c *LRU) Add(key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*entry).value = value
+		return false
+	}
+
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value})
+	if c.max <= 0 || c.ll.Len() <= c.max {
+		return false
+	}
+
+	oldest := c.ll.Back()
+	c.ll.Remove(oldest)
+	delete(c.items, oldest.Value.(*entry).key)
+
+	return true
+}
+
+// Remove deletes the entry of key
+func (c *LRU) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// Keys returns the cached keys from the most to the least recently used
+func (c *LRU) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, c.ll.Len())
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*entry).key)
+	}
+
+	return keys
+}
+
+// Len returns the number of cached entries
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// Group runs at most one call per key at a time; concurrent callers of the same key wait for
+// and share its result. The zero value is ready to use.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// Do calls fn unless a call for key is in flight, in which case it waits for that call and
+// returns its result
+func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.value, c.err = fn()
+
+	return c.value, c.err
+}
@@ -0,0 +1,97 @@
+package cache_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kraneware/kws/internal/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCache(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Internal Cache Suite")
+}
+
+var _ = Describe("LRU", func() {
+	It("should evict the least recently used entry", func() {
+		c := cache.NewLRU(2)
+		Expect(c.Add("a", 1)).Should(BeFalse())
+		Expect(c.Add("b", 2)).Should(BeFalse())
+
+		v, ok := c.Get("a")
+		Expect(ok).Should(BeTrue())
+		Expect(v).Should(Equal(1))
+
+		Expect(c.Add("c", 3)).Should(BeTrue())
+		_, ok = c.Get("b")
+		Expect(ok).Should(BeFalse())
+		Expect(c.Keys()).Should(Equal([]string{"c", "a"}))
+
+		Expect(c.Add("a", 4)).Should(BeFalse())
+		v, _ = c.Get("a")
+		Expect(v).Should(Equal(4))
+
+		c.Remove("a")
+		c.Remove("missing")
+		Expect(c.Len()).Should(Equal(1))
+	})
+
+	It("should be unbounded without a max", func() {
+		c := cache.NewLRU(0)
+		for _, k := range []string{"a", "b", "c"} {
+			Expect(c.Add(k, k)).Should(BeFalse())
+		}
+		Expect(c.Len()).Should(Equal(3))
+	})
+})
+
+var _ = Describe("Group", func() {
+	It("should share the result of concurrent calls", func() {
+		var (
+			g       cache.Group
+			calls   int32
+			wg      sync.WaitGroup
+			release = make(chan struct{})
+		)
+
+		results := make([]interface{}, 5)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], _ = g.Do("k", func() (interface{}, error) {
+					atomic.AddInt32(&calls, 1)
+					<-release
+					return "v", nil
+				})
+			}(i)
+		}
+
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(Equal(int32(1)))
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		Expect(atomic.LoadInt32(&calls)).Should(Equal(int32(1)))
+		for _, r := range results {
+			Expect(r).Should(Equal("v"))
+		}
+	})
+
+	It("should run a new call once the previous one returned", func() {
+		var g cache.Group
+		boom := errors.New("boom")
+
+		_, err := g.Do("k", func() (interface{}, error) { return nil, boom })
+		Expect(err).Should(Equal(boom))
+
+		v, err := g.Do("k", func() (interface{}, error) { return 2, nil })
+		Expect(err).Should(BeNil())
+		Expect(v).Should(Equal(2))
+	})
+})
@@ -1,11 +1,13 @@
 package services
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/kraneware/kws/config"
 	"sync"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
@@ -32,79 +34,122 @@ func DynamoDbClient() *dynamodb.DynamoDB {
 
 // UnmarshalStreamImage coverts images incoming from DynamoDB streams to given struct
 func UnmarshalStreamImage(attribute map[string]events.DynamoDBAttributeValue, out interface{}) (err error) {
-	dbAttrMap := make(map[string]*dynamodb.AttributeValue)
+	return dynamodbattribute.UnmarshalMap(StreamImageToAttributeMap(attribute), out)
+}
 
-	for k, v := range attribute {
-		if err == nil {
-			var dbAttr dynamodb.AttributeValue
+// MarshalStreamImage takes a struct and converts it into stream image for streaming DynamoDB events
+func MarshalStreamImage(in interface{}) (result map[string]events.DynamoDBAttributeValue, err error) {
+	var avMap map[string]*dynamodb.AttributeValue
+	avMap, err = dynamodbattribute.MarshalMap(in)
+	if err == nil {
+		result, err = AttributeMapToStreamImage(avMap)
+	}
 
-			var bytes []byte
-			bytes, err = json.Marshal(v)
+	return result, err
+}
 
-			if err == nil {
-				err = json.Unmarshal(bytes, &dbAttr)
-				if err == nil {
-					dbAttrMap[k] = &dbAttr
-				}
-			}
-		}
+// StreamImageToAttributeMap converts a stream image into an SDK attribute map
+func StreamImageToAttributeMap(image map[string]events.DynamoDBAttributeValue) map[string]*dynamodb.AttributeValue {
+	if image == nil {
+		return nil
 	}
 
-	if err == nil {
-		err = dynamodbattribute.UnmarshalMap(dbAttrMap, out)
+	out := make(map[string]*dynamodb.AttributeValue, len(image))
+	for k, v := range image {
+		out[k] = StreamToAttributeValue(v)
 	}
 
-	return err
+	return out
 }
 
-// removeNullValues removes keys with null values.  This is needed due to issue in
-// marshaling and unmashaling events.DynamoDBAttributeValue
-func removeNullValues(in map[string]interface{}) {
-	for k, v := range in {
-		if v == nil {
-			delete(in, k)
-		} else if m, ok := v.(map[string]interface{}); ok {
-			removeNullValues(m)
-		} else if l, ok := v.([]interface{}); ok { // handle the case of a list of value
-			for _, i := range l {
-				if m, ok := i.(map[string]interface{}); ok {
-					removeNullValues(m)
-				}
-			}
+// AttributeMapToStreamImage converts an SDK attribute map into a stream image
+func AttributeMapToStreamImage(item map[string]*dynamodb.AttributeValue) (map[string]events.DynamoDBAttributeValue, error) {
+	out := make(map[string]events.DynamoDBAttributeValue, len(item))
+	for k, v := range item {
+		av, err := AttributeValueToStream(v)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", k, err)
 		}
+		out[k] = av
 	}
+
+	return out, nil
 }
 
-// MarshalStreamImage takes a struct and converts it into stream image for streaming DynamoDB events
-func MarshalStreamImage(in interface{}) (result map[string]events.DynamoDBAttributeValue, err error) {
-	result = make(map[string]events.DynamoDBAttributeValue)
+// StreamToAttributeValue converts a single stream attribute into an SDK attribute value
+func StreamToAttributeValue(in events.DynamoDBAttributeValue) *dynamodb.AttributeValue {
+	if in.IsNull() {
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+	}
 
-	var avMap map[string]*dynamodb.AttributeValue
-	avMap, err = dynamodbattribute.MarshalMap(in)
-	if err == nil {
-		for k, v := range avMap {
-			var jsonBytes []byte
-			jsonBytes, err = json.Marshal(v)
-			if err == nil {
-				var tempMap map[string]interface{}
-				err = json.Unmarshal(jsonBytes, &tempMap)
-				if err == nil {
-					removeNullValues(tempMap)
-
-					var bytes []byte
-					bytes, err = json.Marshal(tempMap)
-
-					if err == nil {
-						var item events.DynamoDBAttributeValue
-						err = json.Unmarshal(bytes, &item)
-						if err == nil {
-							result[k] = item
-						}
-					}
-				}
+	switch in.DataType() {
+	case events.DataTypeString:
+		return &dynamodb.AttributeValue{S: aws.String(in.String())}
+	case events.DataTypeNumber:
+		return &dynamodb.AttributeValue{N: aws.String(in.Number())}
+	case events.DataTypeBinary:
+		return &dynamodb.AttributeValue{B: in.Binary()}
+	case events.DataTypeBoolean:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(in.Boolean())}
+	case events.DataTypeStringSet:
+		return &dynamodb.AttributeValue{SS: aws.StringSlice(in.StringSet())}
+	case events.DataTypeNumberSet:
+		return &dynamodb.AttributeValue{NS: aws.StringSlice(in.NumberSet())}
+	case events.DataTypeBinarySet:
+		return &dynamodb.AttributeValue{BS: in.BinarySet()}
+	case events.DataTypeList:
+		l := in.List()
+		out := make([]*dynamodb.AttributeValue, len(l))
+		for i, v := range l {
+			out[i] = StreamToAttributeValue(v)
+		}
+		return &dynamodb.AttributeValue{L: out}
+	case events.DataTypeMap:
+		return &dynamodb.AttributeValue{M: StreamImageToAttributeMap(in.Map())}
+	}
+
+	return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+}
+
+// AttributeValueToStream converts a single SDK attribute value into a stream attribute. An
+// attribute value with no member set is reported as an error.
+func AttributeValueToStream(in *dynamodb.AttributeValue) (events.DynamoDBAttributeValue, error) {
+	switch {
+	case in == nil:
+		return events.NewNullAttribute(), nil
+	case in.S != nil:
+		return events.NewStringAttribute(*in.S), nil
+	case in.N != nil:
+		return events.NewNumberAttribute(*in.N), nil
+	case in.B != nil:
+		return events.NewBinaryAttribute(in.B), nil
+	case in.BOOL != nil:
+		return events.NewBooleanAttribute(*in.BOOL), nil
+	case in.NULL != nil:
+		return events.NewNullAttribute(), nil
+	case in.SS != nil:
+		return events.NewStringSetAttribute(aws.StringValueSlice(in.SS)), nil
+	case in.NS != nil:
+		return events.NewNumberSetAttribute(aws.StringValueSlice(in.NS)), nil
+	case in.BS != nil:
+		return events.NewBinarySetAttribute(in.BS), nil
+	case in.L != nil:
+		out := make([]events.DynamoDBAttributeValue, len(in.L))
+		for i, v := range in.L {
+			av, err := AttributeValueToStream(v)
+			if err != nil {
+				return av, err
 			}
+			out[i] = av
+		}
+		return events.NewListAttribute(out), nil
+	case in.M != nil:
+		m, err := AttributeMapToStreamImage(in.M)
+		if err != nil {
+			return events.DynamoDBAttributeValue{}, err
 		}
+		return events.NewMapAttribute(m), nil
 	}
 
-	return result, err
+	return events.DynamoDBAttributeValue{}, errors.New("empty attribute value")
 }
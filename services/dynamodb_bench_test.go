@@ -0,0 +1,158 @@
+package services_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/kraneware/kws/services"
+)
+
+type benchItem struct {
+	ID      string            `json:"id"`
+	Count   int               `json:"count"`
+	Active  bool              `json:"active"`
+	Tags    []string          `json:"tags"`
+	Scores  []float64         `json:"scores"`
+	Labels  map[string]string `json:"labels"`
+	Payload []byte            `json:"payload"`
+}
+
+var benchValue = benchItem{
+	ID:      "0f8fad5b-d9cb-469f-a165-70867728950e",
+	Count:   42,
+	Active:  true,
+	Tags:    []string{"a", "b", "c"},
+	Scores:  []float64{1.5, 2.5, 3.5},
+	Labels:  map[string]string{"env": "prod", "team": "core"},
+	Payload: []byte("payload"),
+}
+
+// legacyUnmarshalStreamImage is the former JSON round trip implementation, kept for comparison
+func legacyUnmarshalStreamImage(attribute map[string]events.DynamoDBAttributeValue, out interface{}) (err error) {
+	dbAttrMap := make(map[string]*dynamodb.AttributeValue)
+
+	for k, v := range attribute {
+		if err == nil {
+			var dbAttr dynamodb.AttributeValue
+
+			var bytes []byte
+			bytes, err = json.Marshal(v)
+
+			if err == nil {
+				err = json.Unmarshal(bytes, &dbAttr)
+				if err == nil {
+					dbAttrMap[k] = &dbAttr
+				}
+			}
+		}
+	}
+
+	if err == nil {
+		err = dynamodbattribute.UnmarshalMap(dbAttrMap, out)
+	}
+
+	return err
+}
+
+func legacyRemoveNullValues(in map[string]interface{}) {
+	for k, v := range in {
+		if v == nil {
+			delete(in, k)
+		} else if m, ok := v.(map[string]interface{}); ok {
+			legacyRemoveNullValues(m)
+		} else if l, ok := v.([]interface{}); ok {
+			for _, i := range l {
+				if m, ok := i.(map[string]interface{}); ok {
+					legacyRemoveNullValues(m)
+				}
+			}
+		}
+	}
+}
+
+// legacyMarshalStreamImage is the former double JSON implementation, kept for comparison
+func legacyMarshalStreamImage(in interface{}) (result map[string]events.DynamoDBAttributeValue, err error) {
+	result = make(map[string]events.DynamoDBAttributeValue)
+
+	var avMap map[string]*dynamodb.AttributeValue
+	avMap, err = dynamodbattribute.MarshalMap(in)
+	if err == nil {
+		for k, v := range avMap {
+			var jsonBytes []byte
+			jsonBytes, err = json.Marshal(v)
+			if err == nil {
+				var tempMap map[string]interface{}
+				err = json.Unmarshal(jsonBytes, &tempMap)
+				if err == nil {
+					legacyRemoveNullValues(tempMap)
+
+					var bytes []byte
+					bytes, err = json.Marshal(tempMap)
+
+					if err == nil {
+						var item events.DynamoDBAttributeValue
+						err = json.Unmarshal(bytes, &item)
+						if err == nil {
+							result[k] = item
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return result, err
+}
+
+func BenchmarkMarshalStreamImage(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := services.MarshalStreamImage(benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalStreamImageJSON(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := legacyMarshalStreamImage(benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalStreamImage(b *testing.B) {
+	image, err := services.MarshalStreamImage(benchValue)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var out benchItem
+		if err := services.UnmarshalStreamImage(image, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalStreamImageJSON(b *testing.B) {
+	image, err := services.MarshalStreamImage(benchValue)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var out benchItem
+		if err := legacyUnmarshalStreamImage(image, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	klambda "github.com/kraneware/kws/lambda"
+)
+
+// Environment of the AWS Parameters and Secrets Lambda Extension
+const (
+	ExtensionPortEnv     = "PARAMETERS_SECRETS_EXTENSION_HTTP_PORT"
+	ExtensionDefaultPort = "2773"
+	ExtensionTokenHeader = "X-Aws-Parameters-Secrets-Token"
+)
+
+// Extension reads secrets and parameters through the local HTTP cache of the AWS Parameters
+// and Secrets Lambda Extension. Lookups the extension cannot serve, because the layer is not
+// attached or fails, go to the direct client instead.
+type Extension struct {
+	// Endpoint is the base URL of the extension, http://localhost:2773 by default
+	Endpoint string
+
+	// Token authenticates the requests, the AWS_SESSION_TOKEN of the function
+	Token string
+
+	HTTPClient *http.Client
+
+	mu          sync.Mutex
+	unreachable bool
+}
+
+// DetectExtension returns the extension of the current Lambda function, or nil outside of
+// Lambda
+func DetectExtension() *Extension {
+	token := os.Getenv("AWS_SESSION_TOKEN")
+	if os.Getenv(klambda.LambdaExecutionEnvironment) == "" || token == "" {
+		return nil
+	}
+
+	port := os.Getenv(ExtensionPortEnv)
+	if port == "" {
+		port = ExtensionDefaultPort
+	}
+
+	return &Extension{
+		Endpoint:   "http://localhost:" + port,
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 3 * time.Second},
+	}
+}
+
+// nolint:gochecknoglobals
+var (
+	extension     *Extension
+	extensionInit sync.Once
+)
+
+func defaultExtension() *Extension {
+	extensionInit.Do(func() {
+		extension = DetectExtension()
+	})
+
+	return extension
+}
+
+// SecretsManager returns a client serving GetSecretValue from the extension and every other
+// call, or a failed lookup, from fallback
+func (e *Extension) SecretsManager(fallback secretsmanageriface.SecretsManagerAPI) secretsmanageriface.SecretsManagerAPI {
+	return &extensionSecrets{SecretsManagerAPI: fallback, ext: e}
+}
+
+// SSM returns a client serving GetParameter and GetParameters from the extension and every
+// other call, or a failed lookup, from fallback
+func (e *Extension) SSM(fallback ssmiface.SSMAPI) ssmiface.SSMAPI {
+	return &extensionSSM{SSMAPI: fallback, ext: e}
+}
+
+type extensionSecrets struct {
+	secretsmanageriface.SecretsManagerAPI
+	ext *Extension
+}
+
+type extensionSecretValue struct {
+	ARN           string
+	Name          string
+	VersionID     string `json:"VersionId"`
+	SecretString  *string
+	SecretBinary  []byte
+	VersionStages []string
+}
+
+func (s *extensionSecrets) GetSecretValueWithContext(ctx aws.Context, in *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	q := url.Values{"secretId": {aws.StringValue(in.SecretId)}}
+	if in.VersionId != nil {
+		q.Set("versionId", *in.VersionId)
+	}
+	if in.VersionStage != nil {
+		q.Set("versionStage", *in.VersionStage)
+	}
+
+	var v extensionSecretValue
+	found, err := s.ext.get(ctx, "/secretsmanager/get", q, &v)
+	if err != nil {
+		return s.SecretsManagerAPI.GetSecretValueWithContext(ctx, in, opts...)
+	}
+	if !found {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found by the extension", nil)
+	}
+
+	return &secretsmanager.GetSecretValueOutput{
+		ARN:           aws.String(v.ARN),
+		Name:          aws.String(v.Name),
+		VersionId:     aws.String(v.VersionID),
+		SecretString:  v.SecretString,
+		SecretBinary:  v.SecretBinary,
+		VersionStages: aws.StringSlice(v.VersionStages),
+	}, nil
+}
+
+type extensionSSM struct {
+	ssmiface.SSMAPI
+	ext *Extension
+}
+
+type extensionParameter struct {
+	Parameter struct {
+		ARN     string
+		Name    string
+		Type    string
+		Value   string
+		Version int64
+	}
+}
+
+func (s *extensionSSM) GetParameterWithContext(ctx aws.Context, in *ssm.GetParameterInput, opts ...request.Option) (*ssm.GetParameterOutput, error) {
+	p, found, err := s.parameter(ctx, aws.StringValue(in.Name), aws.BoolValue(in.WithDecryption))
+	if err != nil {
+		return s.SSMAPI.GetParameterWithContext(ctx, in, opts...)
+	}
+	if !found {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found by the extension", nil)
+	}
+
+	return &ssm.GetParameterOutput{Parameter: p}, nil
+}
+
+// GetParametersWithContext looks up each name through the extension, which has no batch
+// endpoint. The names it cannot serve are read from the fallback client in one call.
+func (s *extensionSSM) GetParametersWithContext(ctx aws.Context, in *ssm.GetParametersInput, opts ...request.Option) (*ssm.GetParametersOutput, error) {
+	out := &ssm.GetParametersOutput{}
+	var fallback []*string
+	for _, name := range aws.StringValueSlice(in.Names) {
+		p, found, err := s.parameter(ctx, name, aws.BoolValue(in.WithDecryption))
+		switch {
+		case err != nil:
+			fallback = append(fallback, aws.String(name))
+		case found:
+			out.Parameters = append(out.Parameters, p)
+		default:
+			out.InvalidParameters = append(out.InvalidParameters, aws.String(name))
+		}
+	}
+	if len(fallback) == 0 {
+		return out, nil
+	}
+
+	direct, err := s.SSMAPI.GetParametersWithContext(ctx, &ssm.GetParametersInput{Names: fallback, WithDecryption: in.WithDecryption}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out.Parameters = append(out.Parameters, direct.Parameters...)
+	out.InvalidParameters = append(out.InvalidParameters, direct.InvalidParameters...)
+
+	return out, nil
+}
+
+func (s *extensionSSM) parameter(ctx aws.Context, name string, decrypt bool) (*ssm.Parameter, bool, error) {
+	q := url.Values{
+		"name":           {name},
+		"withDecryption": {fmt.Sprint(decrypt)},
+	}
+
+	var v extensionParameter
+	found, err := s.ext.get(ctx, "/systemsmanager/parameters/get", q, &v)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	return &ssm.Parameter{
+		ARN:     aws.String(v.Parameter.ARN),
+		Name:    aws.String(v.Parameter.Name),
+		Type:    aws.String(v.Parameter.Type),
+		Value:   aws.String(v.Parameter.Value),
+		Version: aws.Int64(v.Parameter.Version),
+	}, true, nil
+}
+
+// get decodes the JSON response of the extension into out. It reports false for values the
+// extension does not find, and an error when the lookup must fall back to the direct client.
+func (e *Extension) get(ctx aws.Context, path string, q url.Values, out interface{}) (bool, error) {
+	e.mu.Lock()
+	unreachable := e.unreachable
+	e.mu.Unlock()
+	if unreachable {
+		return false, fmt.Errorf("extension at %s is unreachable", e.Endpoint)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.Endpoint+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(ExtensionTokenHeader, e.Token)
+
+	client := e.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// without the layer nothing listens on the port; stop trying for this container. Other
+		// failures, such as timeouts, may be transient and are retried on the next lookup.
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			e.mu.Lock()
+			e.unreachable = true
+			e.mu.Unlock()
+		}
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, json.Unmarshal(body, out)
+	case http.StatusBadRequest:
+		// the extension passes on the error of the service it called
+		var apiErr struct {
+			Type string `json:"__type"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && extensionNotFound[apiErr.Type] {
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf("extension returned %s: %s", resp.Status, body)
+}
+
+// extensionNotFound holds the error types the extension returns for missing values
+// nolint:gochecknoglobals
+var extensionNotFound = map[string]bool{
+	secretsmanager.ErrCodeResourceNotFoundException: true,
+	ssm.ErrCodeParameterNotFound:                    true,
+}
@@ -0,0 +1,160 @@
+package services_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	klambda "github.com/kraneware/kws/lambda"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extension", func() {
+	var (
+		server   *httptest.Server
+		requests int
+		ext      *services.Extension
+		secrets  *fakeSecrets
+		params   *fakeSSM
+	)
+
+	BeforeEach(func() {
+		requests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.Header.Get(services.ExtensionTokenHeader) != "token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			q := r.URL.Query()
+			switch {
+			case r.URL.Path == "/secretsmanager/get" && q.Get("secretId") == "db":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"Name":          "db",
+					"SecretString":  "from extension " + q.Get("versionStage"),
+					"VersionId":     "v1",
+					"VersionStages": []string{"AWSCURRENT"},
+				})
+			case r.URL.Path == "/systemsmanager/parameters/get" && q.Get("name") == "/app/host":
+				Expect(q.Get("withDecryption")).Should(Equal("true"))
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"Parameter": map[string]interface{}{"Name": "/app/host", "Value": "ext.local", "Version": 3},
+				})
+			case q.Get("secretId") == "broken" || q.Get("name") == "/app/port":
+				w.WriteHeader(http.StatusInternalServerError)
+			case q.Get("secretId") == "denied":
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type":"AccessDeniedException","message":"NotFound"}`))
+			case q.Get("secretId") == "reset":
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				_ = conn.Close()
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException"}`))
+			}
+		}))
+
+		ext = &services.Extension{Endpoint: server.URL, Token: "token"}
+		secrets = &fakeSecrets{secrets: map[string]string{"db": "direct", "broken": "direct", "denied": "direct", "reset": "direct"}}
+		params = &fakeSSM{params: map[string]string{"/app/host": "direct.local", "/app/port": "80"}, calls: make(map[string]int)}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should read secrets through the extension", func() {
+		v, err := services.GetSecretVersion(aws.BackgroundContext(), ext.SecretsManager(secrets), "db", services.SecretVersion{Stage: services.SecretStagePrevious})
+		Expect(err).Should(BeNil())
+		Expect(v.String).Should(Equal("from extension AWSPREVIOUS"))
+		Expect(v.VersionID).Should(Equal("v1"))
+		Expect(secrets.count()).Should(Equal(0))
+
+		_, err = services.GetSecret(ext.SecretsManager(secrets), "missing")
+		Expect(errors.Is(err, services.ErrSecretNotFound)).Should(BeTrue())
+	})
+
+	It("should read parameters through the extension", func() {
+		svc := ext.SSM(params)
+		Expect(services.GetParameter(aws.BackgroundContext(), svc, "/app/host")).Should(Equal("ext.local"))
+
+		_, err := services.GetParameter(aws.BackgroundContext(), svc, "/app/missing")
+		Expect(errors.Is(err, services.ErrParameterNotFound)).Should(BeTrue())
+
+		// batch lookups go through the extension name by name; only failed names fall back
+		values, err := services.GetParameters(aws.BackgroundContext(), svc, []string{"/app/host", "/app/port", "/app/missing"})
+		Expect(errors.Is(err, services.ErrParameterNotFound)).Should(BeTrue())
+		Expect(values).Should(Equal(map[string]string{"/app/host": "ext.local", "/app/port": "80"}))
+		Expect(params.count("GetParameter")).Should(Equal(0))
+		Expect(params.count("GetParameters")).Should(Equal(1))
+	})
+
+	It("should serve lookups with the default clients", func() {
+		services.SetProvider(services.AWSProvider{Extension: ext})
+		defer services.SetProvider(nil)
+
+		Expect(services.GetSecret(services.SecretClient(), "db")).Should(Equal("from extension AWSCURRENT"))
+		Expect(services.GetParameter(aws.BackgroundContext(), services.SSMClient(), "/app/host")).Should(Equal("ext.local"))
+		Expect(services.GetParameter(aws.BackgroundContext(), nil, "/app/host")).Should(Equal("ext.local"))
+		Expect(requests).Should(Equal(3))
+	})
+
+	It("should fall back to the direct clients", func() {
+		Expect(services.GetSecret(ext.SecretsManager(secrets), "broken")).Should(Equal("direct"))
+
+		// only a not found error type means the value is missing
+		Expect(services.GetSecret(ext.SecretsManager(secrets), "denied")).Should(Equal("direct"))
+
+		ext.Token = "expired"
+		Expect(services.GetSecret(ext.SecretsManager(secrets), "db")).Should(Equal("direct"))
+
+		server.Close()
+		Expect(services.GetParameter(aws.BackgroundContext(), ext.SSM(params), "/app/host")).Should(Equal("direct.local"))
+		Expect(services.GetSecret(ext.SecretsManager(secrets), "db")).Should(Equal("direct"))
+		Expect(secrets.count()).Should(Equal(4))
+	})
+
+	It("should stop calling an unreachable extension", func() {
+		server.Close()
+		for i := 0; i < 3; i++ {
+			Expect(services.GetSecret(ext.SecretsManager(secrets), "db")).Should(Equal("direct"))
+		}
+		Expect(requests).Should(Equal(0))
+	})
+
+	It("should keep calling the extension after other transport errors", func() {
+		Expect(services.GetSecret(ext.SecretsManager(secrets), "reset")).Should(Equal("direct"))
+		Expect(services.GetSecret(ext.SecretsManager(secrets), "db")).Should(Equal("from extension AWSCURRENT"))
+		Expect(requests).Should(Equal(2))
+		Expect(secrets.count()).Should(Equal(1))
+	})
+
+	It("should be detected in Lambda only", func() {
+		for _, k := range []string{klambda.LambdaExecutionEnvironment, "AWS_SESSION_TOKEN", services.ExtensionPortEnv} {
+			if v, ok := os.LookupEnv(k); ok {
+				defer os.Setenv(k, v)
+			} else {
+				defer os.Unsetenv(k)
+			}
+		}
+
+		os.Unsetenv(klambda.LambdaExecutionEnvironment)
+		Expect(services.DetectExtension()).Should(BeNil())
+
+		os.Setenv(klambda.LambdaExecutionEnvironment, "AWS_Lambda_go1.x")
+		os.Setenv("AWS_SESSION_TOKEN", "session")
+		os.Unsetenv(services.ExtensionPortEnv)
+		e := services.DetectExtension()
+		Expect(e.Endpoint).Should(Equal("http://localhost:2773"))
+		Expect(e.Token).Should(Equal("session"))
+
+		os.Setenv(services.ExtensionPortEnv, "2999")
+		Expect(services.DetectExtension().Endpoint).Should(Equal("http://localhost:2999"))
+	})
+})
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"gopkg.in/yaml.v2"
+)
+
+// AgeIdentityEnv names the age identity file used to decrypt .age files, defaulting to the
+// SOPS_AGE_KEY_FILE of sops
+const AgeIdentityEnv = "KWS_AGE_IDENTITY"
+
+// localVersionID is the version id of every value read from a file
+const localVersionID = "local"
+
+// FileProvider serves secrets and parameters from a local file for offline development. YAML
+// and JSON files hold a secrets and a parameters map; values that are not strings are read as
+// JSON, and lists of parameters as StringList values:
+//
+//	secrets:
+//	  db-creds: {username: admin, password: local}
+//	parameters:
+//	  /myapp/dev/db/host: localhost
+//	  /myapp/dev/hosts: [a, b]
+//
+// In a .env file every KEY=VALUE line is both a secret and a parameter named KEY. Files
+// encrypted with sops are decrypted with "sops --decrypt", and files ending in .age (e.g.
+// secrets.yaml.age) with "age --decrypt"; both tools must be installed.
+type FileProvider struct {
+	Path string
+
+	once       sync.Once
+	secrets    map[string]string
+	parameters map[string]string
+	err        error
+
+	// lists holds the parameters written as lists, served as StringList values
+	lists map[string]bool
+}
+
+// NewFileProvider creates a provider reading the given file on first use
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{Path: path}
+}
+
+// SecretsManager returns a client serving GetSecretValue from the file
+func (p *FileProvider) SecretsManager() secretsmanageriface.SecretsManagerAPI {
+	return &fileSecrets{p: p}
+}
+
+// SSM returns a client serving GetParameter, GetParameters and GetParametersByPath from the
+// file
+func (p *FileProvider) SSM() ssmiface.SSMAPI {
+	return &fileSSM{p: p}
+}
+
+func (p *FileProvider) load() error {
+	p.once.Do(func() {
+		p.secrets, p.parameters, p.lists, p.err = readLocalFile(p.Path)
+		if p.err != nil {
+			p.err = fmt.Errorf("local secrets file %s: %w", p.Path, p.err)
+		}
+	})
+
+	return p.err
+}
+
+type fileSecrets struct {
+	secretsmanageriface.SecretsManagerAPI
+	p *FileProvider
+}
+
+func (s *fileSecrets) GetSecretValueWithContext(_ aws.Context, in *secretsmanager.GetSecretValueInput, _ ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	if err := s.p.load(); err != nil {
+		return nil, err
+	}
+
+	// files hold a single version of each secret
+	v, ok := s.p.secrets[aws.StringValue(in.SecretId)]
+	if stage := aws.StringValue(in.VersionStage); stage != "" && stage != SecretStageCurrent {
+		ok = false
+	}
+	if id := aws.StringValue(in.VersionId); id != "" && id != localVersionID {
+		ok = false
+	}
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException,
+			fmt.Sprintf("secret %s is not in %s", aws.StringValue(in.SecretId), s.p.Path), nil)
+	}
+
+	return &secretsmanager.GetSecretValueOutput{
+		Name:          in.SecretId,
+		SecretString:  aws.String(v),
+		VersionId:     aws.String(localVersionID),
+		VersionStages: aws.StringSlice([]string{SecretStageCurrent}),
+	}, nil
+}
+
+type fileSSM struct {
+	ssmiface.SSMAPI
+	p *FileProvider
+}
+
+func (s *fileSSM) parameter(name string) *ssm.Parameter {
+	t := ssm.ParameterTypeString
+	if s.p.lists[name] {
+		t = ssm.ParameterTypeStringList
+	}
+
+	return &ssm.Parameter{Name: aws.String(name), Type: aws.String(t), Value: aws.String(s.p.parameters[name]), Version: aws.Int64(1)}
+}
+
+func (s *fileSSM) GetParameterWithContext(_ aws.Context, in *ssm.GetParameterInput, _ ...request.Option) (*ssm.GetParameterOutput, error) {
+	if err := s.p.load(); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(in.Name)
+	if _, ok := s.p.parameters[name]; !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, fmt.Sprintf("parameter %s is not in %s", name, s.p.Path), nil)
+	}
+
+	return &ssm.GetParameterOutput{Parameter: s.parameter(name)}, nil
+}
+
+func (s *fileSSM) GetParametersWithContext(_ aws.Context, in *ssm.GetParametersInput, _ ...request.Option) (*ssm.GetParametersOutput, error) {
+	if err := s.p.load(); err != nil {
+		return nil, err
+	}
+
+	out := &ssm.GetParametersOutput{}
+	for _, name := range aws.StringValueSlice(in.Names) {
+		if _, ok := s.p.parameters[name]; ok {
+			out.Parameters = append(out.Parameters, s.parameter(name))
+		} else {
+			out.InvalidParameters = append(out.InvalidParameters, aws.String(name))
+		}
+	}
+
+	return out, nil
+}
+
+func (s *fileSSM) GetParametersByPathPagesWithContext(_ aws.Context, in *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool, _ ...request.Option) error {
+	if err := s.p.load(); err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(aws.StringValue(in.Path), "/") + "/"
+	var names []string
+	for name := range s.p.parameters {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if !aws.BoolValue(in.Recursive) && strings.Contains(name[len(prefix):], "/") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := &ssm.GetParametersByPathOutput{}
+	for _, name := range names {
+		out.Parameters = append(out.Parameters, s.parameter(name))
+	}
+	fn(out, true)
+
+	return nil
+}
+
+// readLocalFile returns the secrets and parameters of a file and the names of the list
+// parameters, decrypting the file first if needed
+func readLocalFile(path string) (map[string]string, map[string]string, map[string]bool, error) {
+	var (
+		b      []byte
+		err    error
+		format = path
+	)
+
+	if strings.HasSuffix(path, ".age") {
+		format = strings.TrimSuffix(path, ".age")
+		b, err = decryptAge(path)
+	} else {
+		b, err = os.ReadFile(path)
+		if err == nil && isSops(b) {
+			b, err = run("sops", "--decrypt", path)
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	lists := make(map[string]bool)
+	switch ext := strings.ToLower(filepath.Ext(format)); {
+	case ext == ".env" || strings.HasSuffix(format, ".env"):
+		values, err := parseDotEnv(b)
+		return values, values, lists, err
+	case ext == ".json":
+		var doc struct {
+			Secrets    map[string]interface{} `json:"secrets"`
+			Parameters map[string]interface{} `json:"parameters"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err = dec.Decode(&doc); err != nil {
+			return nil, nil, nil, err
+		}
+		return localValues(doc.Secrets, nil), localValues(doc.Parameters, lists), lists, nil
+	case ext == ".yaml" || ext == ".yml":
+		var doc struct {
+			Secrets    map[string]interface{} `yaml:"secrets"`
+			Parameters map[string]interface{} `yaml:"parameters"`
+		}
+		if err = yaml.Unmarshal(b, &doc); err != nil {
+			return nil, nil, nil, err
+		}
+		return localValues(doc.Secrets, nil), localValues(doc.Parameters, lists), lists, nil
+	}
+
+	return nil, nil, nil, fmt.Errorf("unsupported file type %q, expected .yaml, .yml, .json or .env", filepath.Ext(format))
+}
+
+// isSops recognises the metadata sops adds to the files it encrypts
+func isSops(b []byte) bool {
+	return bytes.Contains(b, []byte(`"sops":`)) ||
+		bytes.Contains(b, []byte("\nsops:")) ||
+		bytes.Contains(b, []byte("sops_version="))
+}
+
+func decryptAge(path string) ([]byte, error) {
+	identity := os.Getenv(AgeIdentityEnv)
+	if identity == "" {
+		identity = os.Getenv("SOPS_AGE_KEY_FILE")
+	}
+	if identity == "" {
+		return nil, fmt.Errorf("set %s to the age identity file decrypting %s", AgeIdentityEnv, path)
+	}
+
+	return run("age", "--decrypt", "--identity", identity, path)
+}
+
+func run(name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...) // nolint:gosec
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// localValues converts the values of a YAML or JSON map to the strings Secrets Manager and
+// SSM return. When lists is not nil, list values are joined with commas and their keys added
+// to lists; otherwise they are encoded as JSON.
+func localValues(m map[string]interface{}, lists map[string]bool) map[string]string {
+	values := make(map[string]string, len(m))
+	for k, v := range m {
+		switch t := v.(type) {
+		case string:
+			values[k] = t
+		case []interface{}:
+			if lists != nil {
+				lists[k] = true
+				items := make([]string, len(t))
+				for i, item := range t {
+					items[i] = fmt.Sprint(jsonValue(item))
+				}
+				values[k] = strings.Join(items, ",")
+				continue
+			}
+			b, _ := json.Marshal(jsonValue(t))
+			values[k] = string(b)
+		case nil:
+			values[k] = ""
+		default:
+			b, _ := json.Marshal(jsonValue(t))
+			values[k] = string(b)
+		}
+	}
+
+	return values
+}
+
+// jsonValue converts the map[interface{}]interface{} values decoded by yaml.v2 into values
+// encoding/json accepts
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = jsonValue(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = jsonValue(e)
+		}
+	}
+
+	return v
+}
+
+// parseDotEnv reads KEY=VALUE lines, skipping blank lines and comments. Values may be quoted;
+// double quoted values are unescaped.
+func parseDotEnv(b []byte) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d is not KEY=VALUE", n)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			v, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			value = v
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	klambda "github.com/kraneware/kws/lambda"
+)
+
+// LocalSecretsFileEnv names the file of a FileProvider used outside of Lambda
+const LocalSecretsFileEnv = "KWS_LOCAL_SECRETS_FILE"
+
+// Provider supplies the clients that secrets and parameters are read with. The helpers of this
+// package read through the default provider when given SecretClient() or SSMClient(), so
+// calling code is the same whichever provider is selected.
+type Provider interface {
+	SecretsManager() secretsmanageriface.SecretsManagerAPI
+	SSM() ssmiface.SSMAPI
+}
+
+// AWSProvider reads from Secrets Manager and SSM, through the Parameters and Secrets Lambda
+// extension when running in Lambda (see DetectExtension)
+type AWSProvider struct {
+	// Extension defaults to DetectExtension() when nil
+	Extension *Extension
+}
+
+func (p AWSProvider) extension() *Extension {
+	if p.Extension != nil {
+		return p.Extension
+	}
+
+	return defaultExtension()
+}
+
+// SecretsManager returns SecretClient(), behind the extension when it is detected
+func (p AWSProvider) SecretsManager() secretsmanageriface.SecretsManagerAPI {
+	if e := p.extension(); e != nil {
+		return e.SecretsManager(SecretClient())
+	}
+
+	return SecretClient()
+}
+
+// SSM returns SSMClient(), behind the extension when it is detected
+func (p AWSProvider) SSM() ssmiface.SSMAPI {
+	if e := p.extension(); e != nil {
+		return e.SSM(SSMClient())
+	}
+
+	return SSMClient()
+}
+
+// nolint:gochecknoglobals
+var (
+	provider   Provider
+	providerMu sync.Mutex
+)
+
+// DefaultProvider returns the provider set with SetProvider. Otherwise, when the code is not
+// running in Lambda and KWS_LOCAL_SECRETS_FILE is set, it is a FileProvider of that file, and
+// an AWSProvider in every other case.
+func DefaultProvider() Provider {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+
+	if provider == nil {
+		provider = AWSProvider{}
+		if path := os.Getenv(LocalSecretsFileEnv); path != "" && os.Getenv(klambda.LambdaExecutionEnvironment) == "" {
+			provider = NewFileProvider(path)
+		}
+	}
+
+	return provider
+}
+
+// SetProvider replaces the default provider, e.g. with a FileProvider in tests. A nil
+// provider restores the selection from the environment.
+func SetProvider(p Provider) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+
+	provider = p
+}
+
+// SecretReader returns the Secrets Manager client of the default provider. Use SecretClient()
+// to change secrets.
+func SecretReader() secretsmanageriface.SecretsManagerAPI {
+	return DefaultProvider().SecretsManager()
+}
+
+// ParameterReader returns the SSM client of the default provider
+func ParameterReader() ssmiface.SSMAPI {
+	return DefaultProvider().SSM()
+}
+
+// secretReader replaces a nil svc, or the shared SecretClient(), with SecretReader() so that
+// existing GetSecret(SecretClient(), ...) calls read through the default provider too
+func secretReader(svc secretsmanageriface.SecretsManagerAPI) secretsmanageriface.SecretsManagerAPI {
+	if c, ok := svc.(*secretsmanager.SecretsManager); svc == nil || ok && c == SecretClient() {
+		return SecretReader()
+	}
+
+	return svc
+}
+
+// parameterReader replaces a nil svc, or the shared SSMClient(), with ParameterReader()
+func parameterReader(svc ssmiface.SSMAPI) ssmiface.SSMAPI {
+	if c, ok := svc.(*ssm.SSM); svc == nil || ok && c == SSMClient() {
+		return ParameterReader()
+	}
+
+	return svc
+}
@@ -0,0 +1,188 @@
+package services_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	klambda "github.com/kraneware/kws/lambda"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const localYAML = `
+secrets:
+  db-creds:
+    username: admin
+    password: local
+    port: 5432
+  api-key: abc123
+parameters:
+  /myapp/dev/db/host: localhost
+  /myapp/dev/db/port: 5432
+  /myapp/dev/hosts: [a, b]
+  /myapp/dev/dsn: host=db,port=5432
+  /other/key: x
+`
+
+var _ = Describe("Providers", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "kws-provider")
+		Expect(err).Should(BeNil())
+	})
+
+	AfterEach(func() {
+		services.SetProvider(nil)
+		_ = os.RemoveAll(dir)
+	})
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, []byte(content), 0o600)).Should(Succeed())
+		return path
+	}
+
+	It("should serve secrets and parameters from a YAML file", func() {
+		p := services.NewFileProvider(write("secrets.yaml", localYAML))
+
+		var creds services.RDSCredentials
+		Expect(services.GetSecretInto(aws.BackgroundContext(), p.SecretsManager(), "db-creds", &creds)).Should(Succeed())
+		Expect(creds.Username).Should(Equal("admin"))
+		Expect(creds.Port).Should(Equal(5432))
+		Expect(services.GetSecret(p.SecretsManager(), "api-key")).Should(Equal("abc123"))
+
+		_, err := services.GetSecret(p.SecretsManager(), "missing")
+		Expect(errors.Is(err, services.ErrSecretNotFound)).Should(BeTrue())
+		_, err = services.GetSecretVersion(aws.BackgroundContext(), p.SecretsManager(), "api-key", services.SecretVersion{Stage: services.SecretStagePrevious})
+		Expect(errors.Is(err, services.ErrSecretNotFound)).Should(BeTrue())
+
+		Expect(services.GetParameter(aws.BackgroundContext(), p.SSM(), "/myapp/dev/db/port")).Should(Equal("5432"))
+		_, err = services.GetParameter(aws.BackgroundContext(), p.SSM(), "/myapp/dev/missing")
+		Expect(errors.Is(err, services.ErrParameterNotFound)).Should(BeTrue())
+
+		values, err := services.GetParametersByPath(aws.BackgroundContext(), p.SSM(), "/myapp/dev")
+		Expect(err).Should(BeNil())
+		Expect(values).Should(Equal(map[string]string{
+			"/myapp/dev/db/host": "localhost",
+			"/myapp/dev/db/port": "5432",
+			"/myapp/dev/hosts":   "a,b",
+			"/myapp/dev/dsn":     "host=db,port=5432",
+		}))
+
+		// only lists are StringList values, not every value holding a comma
+		for name, t := range map[string]string{"/myapp/dev/hosts": ssm.ParameterTypeStringList, "/myapp/dev/dsn": ssm.ParameterTypeString} {
+			out, err := p.SSM().GetParameterWithContext(aws.BackgroundContext(), &ssm.GetParameterInput{Name: aws.String(name)})
+			Expect(err).Should(BeNil())
+			Expect(aws.StringValue(out.Parameter.Type)).Should(Equal(t), name)
+		}
+
+		values, err = services.GetParameters(aws.BackgroundContext(), p.SSM(), []string{"/other/key", "/nope"})
+		Expect(errors.Is(err, services.ErrParameterNotFound)).Should(BeTrue())
+		Expect(values).Should(Equal(map[string]string{"/other/key": "x"}))
+	})
+
+	It("should read JSON and .env files", func() {
+		p := services.NewFileProvider(write("secrets.json", `{
+			"secrets": {"db-creds": {"username": "admin", "port": "5432"}},
+			"parameters": {"/app/port": 8080}
+		}`))
+		Expect(services.GetSecret(p.SecretsManager(), "db-creds")).Should(MatchJSON(`{"username": "admin", "port": "5432"}`))
+		Expect(services.GetParameter(aws.BackgroundContext(), p.SSM(), "/app/port")).Should(Equal("8080"))
+
+		p = services.NewFileProvider(write(".env", "# local values\nAPI_KEY=abc123\nexport GREETING=\"hello\\nworld\"\nNAME='x y'\n"))
+		Expect(services.GetSecret(p.SecretsManager(), "API_KEY")).Should(Equal("abc123"))
+		Expect(services.GetParameter(aws.BackgroundContext(), p.SSM(), "GREETING")).Should(Equal("hello\nworld"))
+		Expect(services.GetParameter(aws.BackgroundContext(), p.SSM(), "NAME")).Should(Equal("x y"))
+	})
+
+	It("should report unreadable files", func() {
+		_, err := services.GetSecret(services.NewFileProvider(filepath.Join(dir, "missing.yaml")).SecretsManager(), "x")
+		Expect(err).Should(MatchError(ContainSubstring("missing.yaml")))
+
+		_, err = services.GetSecret(services.NewFileProvider(write("secrets.toml", "")).SecretsManager(), "x")
+		Expect(err).Should(MatchError(ContainSubstring("unsupported file type")))
+
+		_, err = services.GetSecret(services.NewFileProvider(write("bad.env", "NOT A PAIR")).SecretsManager(), "x")
+		Expect(err).Should(MatchError(ContainSubstring("line 1")))
+	})
+
+	It("should decrypt sops files", func() {
+		bin := filepath.Join(dir, "bin")
+		Expect(os.Mkdir(bin, 0o700)).Should(Succeed())
+		plain := write("plain.yaml", localYAML)
+		write("bin/sops", "#!/bin/sh\n[ \"$1\" = --decrypt ] && cat "+plain+"\n")
+		Expect(os.Chmod(filepath.Join(bin, "sops"), 0o700)).Should(Succeed())
+
+		defer os.Setenv("PATH", os.Getenv("PATH"))
+		os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		p := services.NewFileProvider(write("secrets.enc.yaml", "secrets:\n  api-key: ENC[AES256_GCM,data:x]\nsops:\n  version: 3.7.3\n"))
+		Expect(services.GetSecret(p.SecretsManager(), "api-key")).Should(Equal("abc123"))
+	})
+
+	It("should decrypt age files", func() {
+		bin := filepath.Join(dir, "bin")
+		Expect(os.Mkdir(bin, 0o700)).Should(Succeed())
+		plain := write("plain.yaml", localYAML)
+		identity := write("key.txt", "AGE-SECRET-KEY-1")
+		write("bin/age", "#!/bin/sh\n[ \"$1 $2 $3\" = \"--decrypt --identity "+identity+"\" ] && cat "+plain+"\n")
+		Expect(os.Chmod(filepath.Join(bin, "age"), 0o700)).Should(Succeed())
+
+		defer os.Setenv("PATH", os.Getenv("PATH"))
+		os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		for _, k := range []string{services.AgeIdentityEnv, "SOPS_AGE_KEY_FILE"} {
+			if v, ok := os.LookupEnv(k); ok {
+				defer os.Setenv(k, v)
+			} else {
+				defer os.Unsetenv(k)
+			}
+			os.Unsetenv(k)
+		}
+
+		encrypted := write("secrets.yaml.age", "age-encryption.org/v1")
+		_, err := services.GetSecret(services.NewFileProvider(encrypted).SecretsManager(), "api-key")
+		Expect(err).Should(MatchError(ContainSubstring(services.AgeIdentityEnv)))
+
+		os.Setenv(services.AgeIdentityEnv, identity)
+		p := services.NewFileProvider(encrypted)
+		Expect(services.GetSecret(p.SecretsManager(), "api-key")).Should(Equal("abc123"))
+		Expect(services.GetParameter(aws.BackgroundContext(), p.SSM(), "/myapp/dev/db/host")).Should(Equal("localhost"))
+	})
+
+	It("should select the file provider outside of Lambda", func() {
+		for _, k := range []string{klambda.LambdaExecutionEnvironment, services.LocalSecretsFileEnv} {
+			if v, ok := os.LookupEnv(k); ok {
+				defer os.Setenv(k, v)
+			} else {
+				defer os.Unsetenv(k)
+			}
+		}
+
+		os.Unsetenv(klambda.LambdaExecutionEnvironment)
+		os.Setenv(services.LocalSecretsFileEnv, write("secrets.yaml", localYAML))
+		services.SetProvider(nil)
+		Expect(services.DefaultProvider()).Should(BeAssignableToTypeOf(&services.FileProvider{}))
+
+		var cfg struct {
+			Host     string `kws:"ssm:///myapp/dev/db/host"`
+			Password string `kws:"secret://db-creds#password"`
+		}
+		Expect(services.ResolveConfig(aws.BackgroundContext(), &cfg)).Should(Succeed())
+		Expect(cfg.Host).Should(Equal("localhost"))
+		Expect(cfg.Password).Should(Equal("local"))
+
+		// existing calls on the default clients read the file too
+		Expect(services.GetSecret(services.SecretClient(), "api-key")).Should(Equal("abc123"))
+		Expect(services.GetParameter(aws.BackgroundContext(), services.SSMClient(), "/myapp/dev/db/port")).Should(Equal("5432"))
+
+		os.Setenv(klambda.LambdaExecutionEnvironment, "AWS_Lambda_go1.x")
+		services.SetProvider(nil)
+		Expect(services.DefaultProvider()).Should(Equal(services.AWSProvider{}))
+	})
+})
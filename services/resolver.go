@@ -0,0 +1,328 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// ErrConfigMissing is matched with errors.Is by a ConfigError reporting missing values
+var ErrConfigMissing = errors.New("missing configuration")
+
+// ConfigError reports every configuration value that could not be resolved
+type ConfigError struct {
+	// Missing lists the fields without a value or default, as "Field (reference)"
+	Missing []string
+
+	// Invalid lists the fields whose value could not be converted or selected
+	Invalid []string
+}
+
+func (e *ConfigError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		parts = append(parts, "invalid "+strings.Join(e.Invalid, ", "))
+	}
+
+	return "configuration: " + strings.Join(parts, "; ")
+}
+
+// Is matches ErrConfigMissing when values are missing
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrConfigMissing && len(e.Missing) > 0
+}
+
+// ConfigResolver fills a configuration struct from environment variables, SSM parameters and
+// Secrets Manager secrets referenced in kws tags:
+//
+//	type config struct {
+//		Port     int           `kws:"env://PORT,default=8080"`
+//		Host     string        `kws:"ssm:///app/db/host"`
+//		Password string        `kws:"secret://db-creds#password"`
+//		APIKey   string        `kws:"secretsmanager://partner-api#key,optional"`
+//		Timeout  time.Duration `kws:"ssm:///app/timeout,default=5s"`
+//	}
+//
+// A #key suffix selects a key of a JSON value. Values are converted to the field type as by
+// ParameterStore.Load and nested structs are resolved too. Parameters are read in batches and
+// each secret once, and every missing or invalid value is reported in a single ConfigError.
+type ConfigResolver struct {
+	// SSM defaults to ParameterReader() and Secrets to SecretReader() when nil
+	SSM     ssmiface.SSMAPI
+	Secrets secretsmanageriface.SecretsManagerAPI
+
+	// LookupEnv defaults to os.LookupEnv
+	LookupEnv func(key string) (string, bool)
+
+	mu       sync.Mutex
+	resolved reflect.Value
+}
+
+type configRef struct {
+	value    reflect.Value
+	field    string
+	ref      string
+	scheme   string
+	name     string
+	key      string
+	def      *string
+	optional bool
+}
+
+// NewConfigResolver creates a resolver using the default clients
+func NewConfigResolver() *ConfigResolver {
+	return &ConfigResolver{}
+}
+
+// ResolveConfig fills the struct pointed to by out using the default clients
+func ResolveConfig(ctx aws.Context, out interface{}) error {
+	return NewConfigResolver().Resolve(ctx, out)
+}
+
+// ResolveOnce resolves out until a call succeeds, typically during a cold start. Later calls
+// copy the configuration resolved then into out, which must have the same type; slices and
+// maps are shared between the copies. A failed attempt is not remembered, so the next
+// invocation tries again.
+func (r *ConfigResolver) ResolveOnce(ctx aws.Context, out interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v := reflect.ValueOf(out)
+	if r.resolved.IsValid() {
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Type() != r.resolved.Type() {
+			return fmt.Errorf("cannot copy the resolved %s into %T", r.resolved.Type(), out)
+		}
+		v.Elem().Set(r.resolved)
+		return nil
+	}
+
+	if err := r.Resolve(ctx, out); err != nil {
+		return err
+	}
+
+	r.resolved = reflect.New(v.Elem().Type()).Elem()
+	r.resolved.Set(v.Elem())
+
+	return nil
+}
+
+// Resolve fills the struct pointed to by out
+func (r *ConfigResolver) Resolve(ctx aws.Context, out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot resolve configuration into %T, a struct pointer is required", out)
+	}
+
+	cerr := &ConfigError{}
+	var refs []*configRef
+	collectRefs(v.Elem(), v.Elem().Type().Name(), &refs, cerr)
+
+	values, err := r.fetch(ctx, refs)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		raw, ok := values[ref.scheme+"://"+ref.name]
+		if ok && ref.key != "" {
+			if raw, ok, err = jsonKey(raw, ref.key); err != nil {
+				cerr.Invalid = append(cerr.Invalid, fmt.Sprintf("%s (%s): %v", ref.field, ref.ref, err))
+				continue
+			}
+		}
+
+		if !ok {
+			switch {
+			case ref.def != nil:
+				raw = *ref.def
+			case ref.optional:
+				continue
+			default:
+				cerr.Missing = append(cerr.Missing, fmt.Sprintf("%s (%s)", ref.field, ref.ref))
+				continue
+			}
+		}
+
+		if err = setFromString(ref.value, raw); err != nil {
+			cerr.Invalid = append(cerr.Invalid, fmt.Sprintf("%s (%s): %v", ref.field, ref.ref, err))
+		}
+	}
+
+	if len(cerr.Missing) > 0 || len(cerr.Invalid) > 0 {
+		return cerr
+	}
+
+	return nil
+}
+
+// fetch returns the values of every reference by scheme and name, leaving out missing values
+func (r *ConfigResolver) fetch(ctx aws.Context, refs []*configRef) (map[string]string, error) {
+	var (
+		values     = make(map[string]string)
+		parameters []string
+		secrets    []string
+		seen       = make(map[string]bool)
+	)
+
+	lookupEnv := r.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	for _, ref := range refs {
+		id := ref.scheme + "://" + ref.name
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		switch ref.scheme {
+		case "env":
+			if v, ok := lookupEnv(ref.name); ok && v != "" {
+				values[id] = v
+			}
+		case "ssm":
+			parameters = append(parameters, ref.name)
+		case "secret":
+			secrets = append(secrets, ref.name)
+		}
+	}
+
+	if len(parameters) > 0 {
+		svc := r.SSM
+		if svc == nil {
+			svc = ParameterReader()
+		}
+
+		found, err := GetParameters(ctx, svc, parameters)
+		if err != nil && !errors.Is(err, ErrParameterNotFound) {
+			return nil, err
+		}
+		for name, v := range found {
+			values["ssm://"+name] = v
+		}
+	}
+
+	if len(secrets) > 0 {
+		svc := r.Secrets
+		if svc == nil {
+			svc = SecretReader()
+		}
+
+		for _, id := range secrets {
+			v, err := GetSecretWithContext(ctx, svc, id)
+			if errors.Is(err, ErrSecretNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			values["secret://"+id] = v
+		}
+	}
+
+	return values, nil
+}
+
+func collectRefs(v reflect.Value, path string, refs *[]*configRef, cerr *ConfigError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		field := path + "." + f.Name
+		tag, ok := f.Tag.Lookup("kws")
+		if !ok {
+			if fv := v.Field(i); fv.Kind() == reflect.Struct && fv.Type() != durationType {
+				collectRefs(fv, field, refs, cerr)
+			}
+			continue
+		}
+
+		ref, err := parseConfigRef(tag)
+		if err != nil {
+			cerr.Invalid = append(cerr.Invalid, fmt.Sprintf("%s: %v", field, err))
+			continue
+		}
+		ref.value = v.Field(i)
+		ref.field = field
+		*refs = append(*refs, ref)
+	}
+}
+
+// parseConfigRef parses a tag such as "secret://db-creds#password,optional" or
+// "env://PORT,default=8080". The default takes the rest of the tag, commas included.
+func parseConfigRef(tag string) (*configRef, error) {
+	ref := &configRef{ref: tag}
+	if i := strings.Index(tag, ","); i >= 0 {
+		ref.ref = tag[:i]
+		for _, opt := range strings.Split(tag[i+1:], ",") {
+			if strings.HasPrefix(opt, "default=") {
+				def := tag[strings.Index(tag, ",default=")+len(",default="):]
+				ref.def = &def
+				break
+			}
+			if opt != "optional" {
+				return nil, fmt.Errorf("unknown option %q", opt)
+			}
+			ref.optional = true
+		}
+	}
+
+	i := strings.Index(ref.ref, "://")
+	if i < 0 {
+		return nil, fmt.Errorf("reference %q has no scheme", ref.ref)
+	}
+	ref.scheme, ref.name = ref.ref[:i], ref.ref[i+len("://"):]
+
+	switch ref.scheme {
+	case "env", "ssm":
+	case "secret", "secretsmanager":
+		ref.scheme = "secret"
+	default:
+		return nil, fmt.Errorf("unknown scheme %q", ref.scheme)
+	}
+
+	if j := strings.Index(ref.name, "#"); j >= 0 {
+		ref.name, ref.key = ref.name[:j], ref.name[j+1:]
+	}
+	if ref.name == "" {
+		return nil, fmt.Errorf("reference %q has no name", ref.ref)
+	}
+
+	return ref, nil
+}
+
+// jsonKey selects a top level key of a JSON object. Values that are not strings are returned
+// as JSON.
+func jsonKey(raw, key string) (string, bool, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return "", false, errors.New("value is not a JSON object")
+	}
+
+	v, ok := doc[key]
+	if !ok || string(v) == "null" {
+		return "", false, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s, true, nil
+	}
+
+	return string(v), true, nil
+}
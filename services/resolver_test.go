@@ -0,0 +1,140 @@
+package services_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type dbConfig struct {
+	Host     string `kws:"ssm:///app/db/host"`
+	Port     int    `kws:"secret://db-creds#port"`
+	User     string `kws:"secretsmanager://db-creds#username"`
+	Password string `kws:"secret://db-creds#password"`
+}
+
+type lambdaConfig struct {
+	Port    int           `kws:"env://PORT,default=8080"`
+	Stage   string        `kws:"env://STAGE"`
+	Timeout time.Duration `kws:"ssm:///app/timeout,default=5s"`
+	Hosts   []string      `kws:"env://HOSTS,default=a,b"`
+	APIKey  string        `kws:"secret://partner#key,optional"`
+	DB      dbConfig
+}
+
+var _ = Describe("ConfigResolver", func() {
+	var (
+		params   *fakeSSM
+		secrets  *fakeSecrets
+		env      map[string]string
+		resolver *services.ConfigResolver
+	)
+
+	BeforeEach(func() {
+		params = &fakeSSM{params: map[string]string{"/app/db/host": "db.local"}, calls: make(map[string]int)}
+		secrets = &fakeSecrets{secrets: map[string]string{
+			"db-creds": `{"username":"admin","password":"pw","port":5432}`,
+		}}
+		env = map[string]string{"STAGE": "prod", "PORT": ""}
+
+		resolver = services.NewConfigResolver()
+		resolver.SSM = params
+		resolver.Secrets = secrets
+		resolver.LookupEnv = func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		}
+	})
+
+	It("should resolve every reference", func() {
+		var cfg lambdaConfig
+		Expect(resolver.Resolve(aws.BackgroundContext(), &cfg)).Should(BeNil())
+		Expect(cfg).Should(Equal(lambdaConfig{
+			Port:    8080,
+			Stage:   "prod",
+			Timeout: 5 * time.Second,
+			Hosts:   []string{"a", "b"},
+			DB:      dbConfig{Host: "db.local", Port: 5432, User: "admin", Password: "pw"},
+		}))
+
+		Expect(params.count("GetParameters")).Should(Equal(1))
+		Expect(secrets.count()).Should(Equal(2))
+	})
+
+	It("should report every missing value at once", func() {
+		delete(env, "STAGE")
+		delete(params.params, "/app/db/host")
+		secrets.secrets["db-creds"] = `{"username":"admin","port":"x"}`
+
+		var cfg lambdaConfig
+		err := resolver.Resolve(aws.BackgroundContext(), &cfg)
+		Expect(errors.Is(err, services.ErrConfigMissing)).Should(BeTrue())
+
+		var cerr *services.ConfigError
+		Expect(errors.As(err, &cerr)).Should(BeTrue())
+		Expect(cerr.Missing).Should(Equal([]string{
+			"lambdaConfig.Stage (env://STAGE)",
+			"lambdaConfig.DB.Host (ssm:///app/db/host)",
+			"lambdaConfig.DB.Password (secret://db-creds#password)",
+		}))
+		Expect(cerr.Invalid).Should(HaveLen(1))
+		Expect(err.Error()).Should(ContainSubstring("invalid lambdaConfig.DB.Port"))
+	})
+
+	It("should reject invalid tags and values", func() {
+		var bad struct {
+			A string `kws:"vault://x"`
+			B string `kws:"PORT"`
+			C string `kws:"env://A,sometimes"`
+			D string `kws:"secret://#key"`
+			E string `kws:"secret://db-creds"`
+			F int    `kws:"secret://plain#key"`
+		}
+		secrets.secrets["plain"] = "not json"
+
+		err := resolver.Resolve(aws.BackgroundContext(), &bad)
+		var cerr *services.ConfigError
+		Expect(errors.As(err, &cerr)).Should(BeTrue())
+		Expect(cerr.Invalid).Should(HaveLen(5))
+		Expect(errors.Is(err, services.ErrConfigMissing)).Should(BeFalse())
+
+		Expect(resolver.Resolve(aws.BackgroundContext(), bad)).ShouldNot(BeNil())
+	})
+
+	It("should fail on service errors", func() {
+		secrets.fail(errors.New("throttled"))
+		var cfg lambdaConfig
+		Expect(resolver.Resolve(aws.BackgroundContext(), &cfg)).ShouldNot(BeAssignableToTypeOf(&services.ConfigError{}))
+	})
+
+	It("should resolve once", func() {
+		var cfg lambdaConfig
+		Expect(resolver.ResolveOnce(aws.BackgroundContext(), &cfg)).Should(BeNil())
+
+		env["STAGE"] = "dev"
+		Expect(resolver.ResolveOnce(aws.BackgroundContext(), &cfg)).Should(BeNil())
+		Expect(cfg.Stage).Should(Equal("prod"))
+		Expect(params.count("GetParameters")).Should(Equal(1))
+
+		// every invocation may pass a new target
+		var next lambdaConfig
+		Expect(resolver.ResolveOnce(aws.BackgroundContext(), &next)).Should(BeNil())
+		Expect(next).Should(Equal(cfg))
+		Expect(resolver.ResolveOnce(aws.BackgroundContext(), &dbConfig{})).ShouldNot(BeNil())
+		Expect(params.count("GetParameters")).Should(Equal(1))
+	})
+
+	It("should retry a failed resolution", func() {
+		secrets.fail(errors.New("throttled"))
+		var cfg lambdaConfig
+		Expect(resolver.ResolveOnce(aws.BackgroundContext(), &cfg)).ShouldNot(BeNil())
+
+		secrets.fail(nil)
+		Expect(resolver.ResolveOnce(aws.BackgroundContext(), &cfg)).Should(BeNil())
+		Expect(cfg.DB.Password).Should(Equal("pw"))
+	})
+})
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	klambda "github.com/kraneware/kws/lambda"
+	"github.com/sirupsen/logrus"
+)
+
+// Rotation steps, in the order Secrets Manager invokes them
+const (
+	RotationStepCreate = "createSecret"
+	RotationStepSet    = "setSecret"
+	RotationStepTest   = "testSecret"
+	RotationStepFinish = "finishSecret"
+)
+
+// RotationEvent is the event Secrets Manager sends to a rotation Lambda for each step
+type RotationEvent struct {
+	SecretID           string `json:"SecretId"`
+	ClientRequestToken string `json:"ClientRequestToken"`
+	Step               string `json:"Step"`
+}
+
+// RotationStrategy implements the secret specific parts of a rotation
+type RotationStrategy interface {
+	// Generate returns the new secret value, typically current with a new password
+	Generate(ctx context.Context, current *SecretValue) (string, error)
+
+	// Apply makes the target (a database, an API) accept the pending value. It may be called
+	// again for the same value when a step is retried.
+	Apply(ctx context.Context, pending, current *SecretValue) error
+
+	// Test checks that the pending value works against the target
+	Test(ctx context.Context, pending *SecretValue) error
+}
+
+// Rotator runs the rotation state machine of Secrets Manager around a RotationStrategy:
+// createSecret stores the generated value as AWSPENDING, setSecret applies it, testSecret
+// tests it and finishSecret moves AWSCURRENT to it. Every step can be safely retried.
+//
+// Example:
+//
+//	func main() {
+//		lambda.Start(services.NewRotator(postgresRotation{}).Handle)
+//	}
+type Rotator struct {
+	Strategy RotationStrategy
+
+	// Logger defaults to an info level klambda.LambdaLogger
+	Logger *klambda.Klogger
+
+	// Client defaults to SecretClient() when nil. Rotation reads it directly, never through
+	// the default provider, whose extension may serve a value cached before the rotation.
+	Client secretsmanageriface.SecretsManagerAPI
+}
+
+// NewRotator creates a rotator for the given strategy
+func NewRotator(strategy RotationStrategy) *Rotator {
+	return &Rotator{Strategy: strategy, Logger: klambda.LambdaLogger(logrus.InfoLevel)}
+}
+
+// Handle runs one step of a rotation
+func (r *Rotator) Handle(ctx context.Context, e RotationEvent) error {
+	svc := r.Client
+	if svc == nil {
+		svc = SecretClient()
+	}
+
+	logger := r.Logger
+	if logger == nil {
+		logger = klambda.LambdaLogger(logrus.InfoLevel)
+	}
+	logger = &klambda.Klogger{Entry: logger.WithFields(logrus.Fields{
+		"secret_id": e.SecretID,
+		"step":      e.Step,
+		"token":     e.ClientRequestToken,
+	})}
+
+	desc, err := svc.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(e.SecretID)})
+	if err != nil {
+		return err
+	}
+	if !aws.BoolValue(desc.RotationEnabled) {
+		return fmt.Errorf("rotation is not enabled for secret %s", e.SecretID)
+	}
+
+	stages, ok := desc.VersionIdsToStages[e.ClientRequestToken]
+	if !ok {
+		return fmt.Errorf("secret %s has no version %s to rotate", e.SecretID, e.ClientRequestToken)
+	}
+	if hasStage(stages, SecretStageCurrent) {
+		logger.Info("version is already AWSCURRENT")
+		return nil
+	}
+	if !hasStage(stages, SecretStagePending) {
+		return fmt.Errorf("version %s of secret %s is not AWSPENDING", e.ClientRequestToken, e.SecretID)
+	}
+
+	switch e.Step {
+	case RotationStepCreate:
+		err = r.create(ctx, svc, logger, e)
+	case RotationStepSet:
+		err = r.set(ctx, svc, e)
+	case RotationStepTest:
+		err = r.test(ctx, svc, e)
+	case RotationStepFinish:
+		err = r.finish(ctx, svc, e, currentVersion(desc.VersionIdsToStages))
+	default:
+		err = fmt.Errorf("unknown rotation step %q", e.Step)
+	}
+
+	if err != nil {
+		logger.WithField("error", err).Error("rotation step failed")
+		return err
+	}
+	logger.Info("rotation step completed")
+
+	return nil
+}
+
+func (r *Rotator) create(ctx context.Context, svc secretsmanageriface.SecretsManagerAPI, logger *klambda.Klogger, e RotationEvent) error {
+	current, err := getSecretVersion(ctx, svc, e.SecretID, SecretVersion{Stage: SecretStageCurrent})
+	if err != nil {
+		return err
+	}
+
+	// a retried createSecret finds the value stored by the previous attempt
+	_, err = getSecretVersion(ctx, svc, e.SecretID, SecretVersion{ID: e.ClientRequestToken, Stage: SecretStagePending})
+	if err == nil {
+		logger.Info("pending value already exists")
+		return nil
+	}
+	if !errors.Is(err, ErrSecretNotFound) {
+		return err
+	}
+
+	value, err := r.Strategy.Generate(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:           aws.String(e.SecretID),
+		ClientRequestToken: aws.String(e.ClientRequestToken),
+		SecretString:       aws.String(value),
+		VersionStages:      aws.StringSlice([]string{SecretStagePending}),
+	})
+
+	return err
+}
+
+func (r *Rotator) set(ctx context.Context, svc secretsmanageriface.SecretsManagerAPI, e RotationEvent) error {
+	pending, err := getSecretVersion(ctx, svc, e.SecretID, SecretVersion{ID: e.ClientRequestToken, Stage: SecretStagePending})
+	if err != nil {
+		return err
+	}
+
+	current, err := getSecretVersion(ctx, svc, e.SecretID, SecretVersion{Stage: SecretStageCurrent})
+	if err != nil {
+		return err
+	}
+
+	return r.Strategy.Apply(ctx, pending, current)
+}
+
+func (r *Rotator) test(ctx context.Context, svc secretsmanageriface.SecretsManagerAPI, e RotationEvent) error {
+	pending, err := getSecretVersion(ctx, svc, e.SecretID, SecretVersion{ID: e.ClientRequestToken, Stage: SecretStagePending})
+	if err != nil {
+		return err
+	}
+
+	return r.Strategy.Test(ctx, pending)
+}
+
+func (r *Rotator) finish(ctx context.Context, svc secretsmanageriface.SecretsManagerAPI, e RotationEvent, current string) error {
+	in := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(e.SecretID),
+		VersionStage:    aws.String(SecretStageCurrent),
+		MoveToVersionId: aws.String(e.ClientRequestToken),
+	}
+	if current != "" {
+		in.RemoveFromVersionId = aws.String(current)
+	}
+
+	_, err := svc.UpdateSecretVersionStageWithContext(ctx, in)
+
+	return err
+}
+
+func currentVersion(versions map[string][]*string) string {
+	for id, stages := range versions {
+		if hasStage(stages, SecretStageCurrent) {
+			return id
+		}
+	}
+
+	return ""
+}
+
+func hasStage(stages []*string, stage string) bool {
+	for _, s := range stages {
+		if aws.StringValue(s) == stage {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,198 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	klambda "github.com/kraneware/kws/lambda"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// fakeVersions models the versions and stage labels of a single secret
+type fakeVersions struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	mu       sync.Mutex
+	enabled  bool
+	values   map[string]string
+	stages   map[string][]string
+	puts     int
+	versions int
+}
+
+func (f *fakeVersions) DescribeSecretWithContext(_ aws.Context, in *secretsmanager.DescribeSecretInput, _ ...request.Option) (*secretsmanager.DescribeSecretOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &secretsmanager.DescribeSecretOutput{
+		Name:               in.SecretId,
+		RotationEnabled:    aws.Bool(f.enabled),
+		VersionIdsToStages: make(map[string][]*string),
+	}
+	for id, stages := range f.stages {
+		out.VersionIdsToStages[id] = aws.StringSlice(stages)
+	}
+
+	return out, nil
+}
+
+func (f *fakeVersions) GetSecretValueWithContext(_ aws.Context, in *secretsmanager.GetSecretValueInput, _ ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for id, stages := range f.stages {
+		if in.VersionId != nil && *in.VersionId != id {
+			continue
+		}
+		if in.VersionStage != nil && !contains(stages, *in.VersionStage) {
+			continue
+		}
+		if v, ok := f.values[id]; ok {
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString:  aws.String(v),
+				VersionId:     aws.String(id),
+				VersionStages: aws.StringSlice(stages),
+			}, nil
+		}
+	}
+
+	return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "version not found", nil)
+}
+
+func (f *fakeVersions) PutSecretValueWithContext(_ aws.Context, in *secretsmanager.PutSecretValueInput, _ ...request.Option) (*secretsmanager.PutSecretValueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.puts++
+
+	f.values[*in.ClientRequestToken] = *in.SecretString
+	f.stages[*in.ClientRequestToken] = aws.StringValueSlice(in.VersionStages)
+
+	return &secretsmanager.PutSecretValueOutput{VersionId: in.ClientRequestToken}, nil
+}
+
+func (f *fakeVersions) UpdateSecretVersionStageWithContext(_ aws.Context, in *secretsmanager.UpdateSecretVersionStageInput, _ ...request.Option) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if in.RemoveFromVersionId != nil {
+		f.stages[*in.RemoveFromVersionId] = []string{services.SecretStagePrevious}
+	}
+	f.stages[*in.MoveToVersionId] = []string{*in.VersionStage}
+
+	return &secretsmanager.UpdateSecretVersionStageOutput{}, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// passwordRotation rotates a password stored by a fake database
+type passwordRotation struct {
+	accepted map[string]bool
+	applied  []string
+	fail     error
+}
+
+func (p *passwordRotation) Generate(_ context.Context, current *services.SecretValue) (string, error) {
+	return current.String + "+1", p.fail
+}
+
+func (p *passwordRotation) Apply(_ context.Context, pending, _ *services.SecretValue) error {
+	p.applied = append(p.applied, pending.String)
+	p.accepted[pending.String] = true
+	return nil
+}
+
+func (p *passwordRotation) Test(_ context.Context, pending *services.SecretValue) error {
+	if !p.accepted[pending.String] {
+		return errors.New("password authentication failed")
+	}
+	return nil
+}
+
+var _ = Describe("Rotator", func() {
+	var (
+		ctx      context.Context
+		fake     *fakeVersions
+		strategy *passwordRotation
+		rotator  *services.Rotator
+	)
+
+	step := func(name string) error {
+		return rotator.Handle(ctx, services.RotationEvent{SecretID: "db", ClientRequestToken: "t2", Step: name})
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = &fakeVersions{
+			enabled: true,
+			values:  map[string]string{"t1": "pw"},
+			stages:  map[string][]string{"t1": {services.SecretStageCurrent}, "t2": {services.SecretStagePending}},
+		}
+		strategy = &passwordRotation{accepted: map[string]bool{"pw": true}}
+		rotator = services.NewRotator(strategy)
+		rotator.Client = fake
+
+		base, _ := test.NewNullLogger()
+		rotator.Logger = klambda.LambdaLoggerCustom(logrus.InfoLevel, base)
+	})
+
+	It("should run every step of a rotation", func() {
+		for _, s := range []string{services.RotationStepCreate, services.RotationStepSet, services.RotationStepTest, services.RotationStepFinish} {
+			Expect(step(s)).Should(BeNil(), s)
+		}
+
+		Expect(fake.values["t2"]).Should(Equal("pw+1"))
+		Expect(strategy.applied).Should(Equal([]string{"pw+1"}))
+		Expect(fake.stages).Should(Equal(map[string][]string{
+			"t1": {services.SecretStagePrevious},
+			"t2": {services.SecretStageCurrent},
+		}))
+
+		// steps retried after the rotation completed are no-ops
+		Expect(step(services.RotationStepFinish)).Should(BeNil())
+	})
+
+	It("should not generate a new value when createSecret is retried", func() {
+		Expect(step(services.RotationStepCreate)).Should(BeNil())
+		Expect(step(services.RotationStepCreate)).Should(BeNil())
+		Expect(fake.puts).Should(Equal(1))
+	})
+
+	It("should fail the test step when the value was not applied", func() {
+		Expect(step(services.RotationStepCreate)).Should(BeNil())
+		Expect(step(services.RotationStepTest)).ShouldNot(BeNil())
+	})
+
+	It("should report strategy errors", func() {
+		strategy.fail = errors.New("no entropy")
+		Expect(step(services.RotationStepCreate)).Should(Equal(strategy.fail))
+	})
+
+	It("should reject invalid events", func() {
+		Expect(step("rewindSecret")).ShouldNot(BeNil())
+		Expect(rotator.Handle(ctx, services.RotationEvent{SecretID: "db", ClientRequestToken: "t9", Step: services.RotationStepCreate})).ShouldNot(BeNil())
+
+		fake.stages["t3"] = nil
+		Expect(rotator.Handle(ctx, services.RotationEvent{SecretID: "db", ClientRequestToken: "t3", Step: services.RotationStepCreate})).ShouldNot(BeNil())
+
+		fake.enabled = false
+		Expect(step(services.RotationStepCreate)).ShouldNot(BeNil())
+	})
+})
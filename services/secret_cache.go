@@ -0,0 +1,200 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/kraneware/kws/internal/cache"
+)
+
+// SecretCacheStats counts the lookups of a SecretCache
+type SecretCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Refreshes int64
+	Errors    int64
+
+	// StaleHits counts expired values served because Secrets Manager could not be reached
+	StaleHits int64
+	Evictions int64
+	Size      int
+}
+
+// SecretCache keeps secret values in memory so that hot Lambda containers do not call
+// GetSecretValue on every use. Values are refreshed in the background when they get close to
+// their TTL, so a rotated secret is picked up within one TTL without blocking callers. When a
+// refresh fails an expired value keeps being served for up to MaxStale.
+//
+// Example:
+//
+//	secrets := services.NewSecretCache(5 * time.Minute)
+//	password, err := secrets.GetSecret(ctx, "prod/db/password")
+type SecretCache struct {
+	// TTL is how long a value is served before it is fetched again, 5 minutes by default
+	TTL time.Duration
+
+	// RefreshAhead starts a background refresh of values older than TTL-RefreshAhead, a fifth
+	// of TTL by default. A negative value disables background refreshes.
+	RefreshAhead time.Duration
+
+	// MaxStale is how long past its TTL a value is served when refreshing it fails, one hour
+	// by default. A negative value disables stale values.
+	MaxStale time.Duration
+
+	// MaxSize bounds the number of secrets held, 100 by default
+	MaxSize int
+
+	// Client defaults to SecretReader() when nil
+	Client secretsmanageriface.SecretsManagerAPI
+
+	once    sync.Once
+	entries *cache.LRU
+	flight  cache.Group
+	stats   SecretCacheStats
+}
+
+type secretEntry struct {
+	value   string
+	fetched time.Time
+
+	// refreshing is set while a background refresh of the entry runs
+	refreshing int32
+}
+
+// NewSecretCache creates a cache serving secret values for ttl
+func NewSecretCache(ttl time.Duration) *SecretCache {
+	return &SecretCache{
+		TTL:          ttl,
+		RefreshAhead: ttl / 5,
+		MaxStale:     time.Hour,
+		MaxSize:      100,
+	}
+}
+
+func (c *SecretCache) init() *cache.LRU {
+	c.once.Do(func() {
+		size := c.MaxSize
+		if size <= 0 {
+			size = 100
+		}
+		c.entries = cache.NewLRU(size)
+	})
+
+	return c.entries
+}
+
+func (c *SecretCache) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return 5 * time.Minute
+	}
+
+	return c.TTL
+}
+
+func (c *SecretCache) refreshAhead() time.Duration {
+	switch {
+	case c.RefreshAhead < 0:
+		return 0
+	case c.RefreshAhead == 0:
+		return c.ttl() / 5
+	}
+
+	return c.RefreshAhead
+}
+
+func (c *SecretCache) maxStale() time.Duration {
+	switch {
+	case c.MaxStale < 0:
+		return 0
+	case c.MaxStale == 0:
+		return time.Hour
+	}
+
+	return c.MaxStale
+}
+
+func (c *SecretCache) client() secretsmanageriface.SecretsManagerAPI {
+	if c.Client != nil {
+		return c.Client
+	}
+
+	return SecretReader()
+}
+
+// GetSecret returns the current value of the secret with the given name or ARN
+func (c *SecretCache) GetSecret(ctx aws.Context, id string) (string, error) {
+	entries := c.init()
+
+	if v, ok := entries.Get(id); ok {
+		e := v.(*secretEntry)
+		age, ttl := time.Since(e.fetched), c.ttl()
+		if age < ttl {
+			atomic.AddInt64(&c.stats.Hits, 1)
+			if age >= ttl-c.refreshAhead() && atomic.CompareAndSwapInt32(&e.refreshing, 0, 1) {
+				go c.refresh(id, e)
+			}
+			return e.value, nil
+		}
+
+		atomic.AddInt64(&c.stats.Misses, 1)
+		value, err := c.fetch(ctx, id)
+		if err != nil && age < ttl+c.maxStale() {
+			atomic.AddInt64(&c.stats.StaleHits, 1)
+			return e.value, nil
+		}
+		return value, err
+	}
+
+	atomic.AddInt64(&c.stats.Misses, 1)
+
+	return c.fetch(ctx, id)
+}
+
+// refresh fetches the secret independently of the caller's context so that a background
+// refresh outlives the invocation that started it. A successful refresh replaces e; after a
+// failure e may be refreshed again.
+func (c *SecretCache) refresh(id string, e *secretEntry) {
+	atomic.AddInt64(&c.stats.Refreshes, 1)
+
+	if _, err := c.fetch(aws.BackgroundContext(), id); err != nil {
+		atomic.StoreInt32(&e.refreshing, 0)
+	}
+}
+
+func (c *SecretCache) fetch(ctx aws.Context, id string) (string, error) {
+	v, err := c.flight.Do(id, func() (interface{}, error) {
+		value, err := GetSecretWithContext(ctx, c.client(), id)
+		if err != nil {
+			atomic.AddInt64(&c.stats.Errors, 1)
+			return "", err
+		}
+
+		if c.init().Add(id, &secretEntry{value: value, fetched: time.Now()}) {
+			atomic.AddInt64(&c.stats.Evictions, 1)
+		}
+		return value, nil
+	})
+
+	return v.(string), err
+}
+
+// Invalidate drops the cached value of the secret, e.g. after rotating it
+func (c *SecretCache) Invalidate(id string) {
+	c.init().Remove(id)
+}
+
+// Stats returns the lookup counters of the cache
+func (c *SecretCache) Stats() SecretCacheStats {
+	return SecretCacheStats{
+		Hits:      atomic.LoadInt64(&c.stats.Hits),
+		Misses:    atomic.LoadInt64(&c.stats.Misses),
+		Refreshes: atomic.LoadInt64(&c.stats.Refreshes),
+		Errors:    atomic.LoadInt64(&c.stats.Errors),
+		StaleHits: atomic.LoadInt64(&c.stats.StaleHits),
+		Evictions: atomic.LoadInt64(&c.stats.Evictions),
+		Size:      c.init().Len(),
+	}
+}
@@ -0,0 +1,143 @@
+package services_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// slowSecrets delays every lookup of the wrapped fake
+type slowSecrets struct {
+	*fakeSecrets
+	delay time.Duration
+}
+
+func (f *slowSecrets) GetSecretValueWithContext(ctx aws.Context, in *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	time.Sleep(f.delay)
+	return f.fakeSecrets.GetSecretValueWithContext(ctx, in, opts...)
+}
+
+var _ = Describe("SecretCache", func() {
+	var (
+		fake *fakeSecrets
+		c    *services.SecretCache
+	)
+
+	BeforeEach(func() {
+		fake = &fakeSecrets{secrets: map[string]string{"db": "v1", "api": "key"}}
+		c = services.NewSecretCache(100 * time.Millisecond)
+		c.RefreshAhead = -1
+		c.Client = fake
+	})
+
+	It("should serve values until they expire", func() {
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		Expect(fake.count()).Should(Equal(1))
+
+		fake.set("db", "v2")
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		time.Sleep(120 * time.Millisecond)
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v2"))
+
+		s := c.Stats()
+		Expect(s.Hits).Should(Equal(int64(2)))
+		Expect(s.Misses).Should(Equal(int64(2)))
+		Expect(s.Size).Should(Equal(1))
+	})
+
+	It("should refresh values ahead of expiry", func() {
+		c.RefreshAhead = 80 * time.Millisecond
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+
+		fake.set("db", "v2")
+		time.Sleep(30 * time.Millisecond)
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		Eventually(fake.count).Should(Equal(2))
+		Eventually(func() string {
+			v, _ := c.GetSecret(aws.BackgroundContext(), "db")
+			return v
+		}).Should(Equal("v2"))
+		Expect(c.Stats().Refreshes).Should(BeNumerically(">=", 1))
+	})
+
+	It("should run one background refresh per entry", func() {
+		slow := &slowSecrets{fakeSecrets: fake}
+		c.Client = slow
+		c.RefreshAhead = 80 * time.Millisecond
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+
+		slow.delay = 40 * time.Millisecond
+		time.Sleep(30 * time.Millisecond)
+		for i := 0; i < 20; i++ {
+			Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		}
+		Eventually(fake.count).Should(Equal(2))
+		Consistently(fake.count, 50*time.Millisecond).Should(Equal(2))
+		Expect(c.Stats().Refreshes).Should(Equal(int64(1)))
+	})
+
+	It("should serve stale values when refreshing fails", func() {
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+
+		fake.fail(errors.New("throttled"))
+		time.Sleep(120 * time.Millisecond)
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		Expect(c.Stats().StaleHits).Should(Equal(int64(1)))
+		Expect(c.Stats().Errors).Should(Equal(int64(1)))
+
+		c.MaxStale = -1
+		_, err := c.GetSecret(aws.BackgroundContext(), "db")
+		Expect(err).ShouldNot(BeNil())
+
+		_, err = c.GetSecret(aws.BackgroundContext(), "api")
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("should apply the defaults to a zero value", func() {
+		c = &services.SecretCache{Client: fake}
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		Expect(fake.count()).Should(Equal(1))
+		Expect(c.Stats().Hits).Should(Equal(int64(1)))
+
+		Expect(c.GetSecret(aws.BackgroundContext(), "api")).Should(Equal("key"))
+		Expect(c.Stats().Size).Should(Equal(2))
+		Expect(c.Stats().Evictions).Should(BeZero())
+	})
+
+	It("should share concurrent fetches", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+			}()
+		}
+		wg.Wait()
+		Expect(fake.count()).Should(BeNumerically("<", 10))
+	})
+
+	It("should bound its size and drop invalidated secrets", func() {
+		c = services.NewSecretCache(time.Minute)
+		c.MaxSize = 1
+		c.Client = fake
+
+		Expect(c.GetSecret(aws.BackgroundContext(), "db")).Should(Equal("v1"))
+		Expect(c.GetSecret(aws.BackgroundContext(), "api")).Should(Equal("key"))
+		Expect(c.Stats().Evictions).Should(Equal(int64(1)))
+		Expect(c.Stats().Size).Should(Equal(1))
+
+		c.Invalidate("api")
+		Expect(c.Stats().Size).Should(Equal(0))
+	})
+})
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	klambda "github.com/kraneware/kws/lambda"
+)
+
+// nolint:gochecknoglobals
+var (
+	secretLogger   *klambda.Klogger
+	secretLoggerMu sync.RWMutex
+)
+
+// SetSecretLogger sets the logger receiving a warning for every failed secret lookup. A nil
+// logger turns the warnings off.
+func SetSecretLogger(logger *klambda.Klogger) {
+	secretLoggerMu.Lock()
+	defer secretLoggerMu.Unlock()
+
+	secretLogger = logger
+}
+
+func currentSecretLogger() *klambda.Klogger {
+	secretLoggerMu.RLock()
+	defer secretLoggerMu.RUnlock()
+
+	return secretLogger
+}
+
+// Errors matched by the SecretError of a failed lookup with errors.Is
+var (
+	ErrSecretNotFound       = errors.New("secret not found")
+	ErrDecryptionFailure    = errors.New("secret cannot be decrypted")
+	ErrSecretAccessDenied   = errors.New("access to secret denied")
+	ErrInvalidSecretRequest = errors.New("invalid secret request")
+	ErrSecretsManagerFailed = errors.New("secrets manager internal error")
+)
+
+const errCodeAccessDenied = "AccessDeniedException"
+
+// SecretError is the error of a failed secret lookup. It wraps the original AWS error, so the
+// awserr.Error is available through errors.As, and matches the Err* value of its code.
+//
+// Example:
+//
+//	if errors.Is(err, services.ErrSecretNotFound) { ... }
+type SecretError struct {
+	SecretID string
+	Code     string
+	Err      error
+}
+
+func newSecretError(id string, err error) *SecretError {
+	e := &SecretError{SecretID: id, Err: err}
+	if aerr, ok := err.(awserr.Error); ok {
+		e.Code = aerr.Code()
+	}
+
+	return e
+}
+
+func (e *SecretError) Error() string {
+	return fmt.Sprintf("secret %s: %v", e.SecretID, e.Err)
+}
+
+// Unwrap returns the AWS error
+func (e *SecretError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the Err* value of the error code
+func (e *SecretError) Is(target error) bool {
+	switch e.Code {
+	case secretsmanager.ErrCodeResourceNotFoundException:
+		return target == ErrSecretNotFound
+	case secretsmanager.ErrCodeDecryptionFailure:
+		return target == ErrDecryptionFailure
+	case errCodeAccessDenied:
+		return target == ErrSecretAccessDenied
+	case secretsmanager.ErrCodeInvalidParameterException, secretsmanager.ErrCodeInvalidRequestException:
+		return target == ErrInvalidSecretRequest
+	case secretsmanager.ErrCodeInternalServiceError:
+		return target == ErrSecretsManagerFailed
+	}
+
+	return false
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// RDSCredentials is the JSON layout of the secrets created by RDS and by the Secrets Manager
+// rotation templates for databases
+type RDSCredentials struct {
+	Username             string `json:"username" required:"true"`
+	Password             string `json:"password" required:"true"`
+	Engine               string `json:"engine,omitempty"`
+	Host                 string `json:"host,omitempty"`
+	Port                 int    `json:"port,omitempty"`
+	DBName               string `json:"dbname,omitempty"`
+	DBInstanceIdentifier string `json:"dbInstanceIdentifier,omitempty"`
+	DBClusterIdentifier  string `json:"dbClusterIdentifier,omitempty"`
+}
+
+// UnmarshalJSON accepts the port as a number or as a string, both of which are found in
+// hand-written secrets
+func (r *RDSCredentials) UnmarshalJSON(b []byte) error {
+	type plain RDSCredentials
+	var v struct {
+		plain
+		Port json.RawMessage `json:"port,omitempty"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	*r = RDSCredentials(v.plain)
+	port := strings.Trim(string(v.Port), `"`)
+	if port == "" || port == "null" {
+		return nil
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", v.Port)
+	}
+	r.Port = p
+
+	return nil
+}
+
+// GetSecretInto decodes the JSON secret with the given name or ARN into out, a pointer to a
+// struct or map. Struct fields tagged `required:"true"` must be present and non-zero.
+func GetSecretInto(ctx aws.Context, svc secretsmanageriface.SecretsManagerAPI, id string, out interface{}) error {
+	secret, err := GetSecretWithContext(ctx, svc, id)
+	if err != nil {
+		return err
+	}
+
+	return decodeSecret(id, secret, out)
+}
+
+// GetSecretInto decodes the cached JSON secret with the given name or ARN into out, see
+// services.GetSecretInto
+func (c *SecretCache) GetSecretInto(ctx aws.Context, id string, out interface{}) error {
+	secret, err := c.GetSecret(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return decodeSecret(id, secret, out)
+}
+
+func decodeSecret(id, secret string, out interface{}) error {
+	if err := json.Unmarshal([]byte(secret), out); err != nil {
+		return fmt.Errorf("secret %s is not valid JSON: %w", id, err)
+	}
+
+	var missing []string
+	requiredFields(reflect.ValueOf(out), "", &missing)
+	if len(missing) > 0 {
+		return fmt.Errorf("secret %s is missing required fields: %s", id, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// requiredFields appends the JSON paths of the zero fields tagged required, descending into
+// nested structs
+func requiredFields(v reflect.Value, prefix string, missing *[]string) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fv := v.Field(i)
+		if f.Tag.Get("required") == "true" && fv.IsZero() {
+			*missing = append(*missing, prefix+name)
+			continue
+		}
+
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			requiredFields(fv, prefix, missing)
+		} else {
+			requiredFields(fv, prefix+name+".", missing)
+		}
+	}
+}
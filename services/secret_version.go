@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// Secret version stages maintained by Secrets Manager rotation
+const (
+	SecretStageCurrent  = "AWSCURRENT"
+	SecretStagePrevious = "AWSPREVIOUS"
+	SecretStagePending  = "AWSPENDING"
+)
+
+// ErrSecretRejected marks errors of an operation that failed because of the secret value
+// itself, such as a database refusing the credentials. Wrap it to have UseSecret fall back to
+// another version:
+//
+//	return fmt.Errorf("%w: %v", services.ErrSecretRejected, err)
+var ErrSecretRejected = errors.New("secret rejected")
+
+// SecretVersion selects a version of a secret by id or by stage. The zero value selects
+// AWSCURRENT.
+type SecretVersion struct {
+	ID    string
+	Stage string
+}
+
+// SecretValue is a secret value with the version it was read from
+type SecretValue struct {
+	String    string
+	VersionID string
+	Stages    []string
+}
+
+// UseSecret runs op with the AWSCURRENT value of the secret. While the secret is being rotated
+// the new value may not be accepted everywhere yet, so when op fails with ErrSecretRejected it
+// is retried with the values of the fallback stages in order (AWSPREVIOUS when none are given).
+// It returns the value op succeeded with, or the first error of op. A fallback stage that
+// does not exist is skipped; any other failure to read one is returned, wrapped.
+func UseSecret(ctx aws.Context, svc secretsmanageriface.SecretsManagerAPI, id string, op func(*SecretValue) error, fallbackStages ...string) (*SecretValue, error) {
+	if len(fallbackStages) == 0 {
+		fallbackStages = []string{SecretStagePrevious}
+	}
+
+	v, err := GetSecretVersion(ctx, svc, id, SecretVersion{Stage: SecretStageCurrent})
+	if err != nil {
+		return nil, err
+	}
+
+	first := op(v)
+	if first == nil || !errors.Is(first, ErrSecretRejected) {
+		if first != nil {
+			return nil, first
+		}
+		return v, nil
+	}
+
+	tried := map[string]bool{v.VersionID: true}
+	for _, stage := range fallbackStages {
+		fallback, err := GetSecretVersion(ctx, svc, id, SecretVersion{Stage: stage})
+		if errors.Is(err, ErrSecretNotFound) {
+			// a secret that was never rotated has no AWSPREVIOUS version
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v; reading its %s value: %w", first, stage, err)
+		}
+		if tried[fallback.VersionID] {
+			continue
+		}
+		tried[fallback.VersionID] = true
+
+		if err = op(fallback); err == nil {
+			return fallback, nil
+		}
+	}
+
+	return nil, first
+}
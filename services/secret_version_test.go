@@ -0,0 +1,113 @@
+package services_test
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Secret versions", func() {
+	var (
+		fake *fakeSecrets
+		used []string
+	)
+
+	// login accepts only the given passwords, like a database in the middle of a rotation
+	login := func(accepted ...string) func(*services.SecretValue) error {
+		return func(v *services.SecretValue) error {
+			used = append(used, v.String)
+			for _, a := range accepted {
+				if v.String == a {
+					return nil
+				}
+			}
+			return fmt.Errorf("%w: password authentication failed", services.ErrSecretRejected)
+		}
+	}
+
+	BeforeEach(func() {
+		used = nil
+		fake = &fakeSecrets{
+			secrets: map[string]string{"db": "new", "fresh": "only"},
+			stages: map[string]string{
+				"db/AWSPREVIOUS": "old",
+				"db/AWSPENDING":  "next",
+				"db/v1":          "first",
+			},
+		}
+	})
+
+	It("should select versions by stage or id", func() {
+		v, err := services.GetSecretVersion(aws.BackgroundContext(), fake, "db", services.SecretVersion{Stage: services.SecretStagePrevious})
+		Expect(err).Should(BeNil())
+		Expect(*v).Should(Equal(services.SecretValue{String: "old", VersionID: "AWSPREVIOUS-id", Stages: []string{"AWSPREVIOUS"}}))
+
+		v, err = services.GetSecretVersion(aws.BackgroundContext(), fake, "db", services.SecretVersion{ID: "v1"})
+		Expect(err).Should(BeNil())
+		Expect(v.String).Should(Equal("first"))
+
+		v, err = services.GetSecretVersion(aws.BackgroundContext(), fake, "db", services.SecretVersion{})
+		Expect(err).Should(BeNil())
+		Expect(v.Stages).Should(Equal([]string{services.SecretStageCurrent}))
+	})
+
+	It("should use the current version when it is accepted", func() {
+		v, err := services.UseSecret(aws.BackgroundContext(), fake, "db", login("new", "old"))
+		Expect(err).Should(BeNil())
+		Expect(v.VersionID).Should(Equal("current-id"))
+		Expect(used).Should(Equal([]string{"new"}))
+	})
+
+	It("should fall back to the previous version while rotating", func() {
+		v, err := services.UseSecret(aws.BackgroundContext(), fake, "db", login("old"))
+		Expect(err).Should(BeNil())
+		Expect(v.Stages).Should(Equal([]string{services.SecretStagePrevious}))
+		Expect(used).Should(Equal([]string{"new", "old"}))
+	})
+
+	It("should try the given fallback stages in order", func() {
+		v, err := services.UseSecret(aws.BackgroundContext(), fake, "db", login("next"), services.SecretStagePrevious, services.SecretStagePending)
+		Expect(err).Should(BeNil())
+		Expect(v.String).Should(Equal("next"))
+		Expect(used).Should(Equal([]string{"new", "old", "next"}))
+	})
+
+	It("should return the first error when no version is accepted", func() {
+		_, err := services.UseSecret(aws.BackgroundContext(), fake, "db", login())
+		Expect(errors.Is(err, services.ErrSecretRejected)).Should(BeTrue())
+
+		// a secret without a previous version
+		used = nil
+		_, err = services.UseSecret(aws.BackgroundContext(), fake, "fresh", login())
+		Expect(err).ShouldNot(BeNil())
+		Expect(used).Should(Equal([]string{"only"}))
+	})
+
+	It("should report fallback versions that cannot be read", func() {
+		_, err := services.UseSecret(aws.BackgroundContext(), fake, "db", func(v *services.SecretValue) error {
+			fake.fail(awserr.New("AccessDeniedException", "not authorized", nil))
+			return login()(v)
+		})
+		Expect(errors.Is(err, services.ErrSecretAccessDenied)).Should(BeTrue())
+		Expect(err.Error()).Should(ContainSubstring("password authentication failed"))
+		Expect(err.Error()).Should(ContainSubstring("AWSPREVIOUS"))
+	})
+
+	It("should not fall back on other errors", func() {
+		boom := errors.New("connection refused")
+		_, err := services.UseSecret(aws.BackgroundContext(), fake, "db", func(*services.SecretValue) error {
+			used = append(used, "x")
+			return boom
+		})
+		Expect(err).Should(Equal(boom))
+		Expect(used).Should(HaveLen(1))
+
+		_, err = services.UseSecret(aws.BackgroundContext(), fake, "missing", login())
+		Expect(err).ShouldNot(BeNil())
+	})
+})
@@ -2,57 +2,54 @@ package services
 
 import (
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/sirupsen/logrus"
 )
 
-func GetSecretByArn(svc *secretsmanager.SecretsManager, arn string) (string, error) {
-
-	input := &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(arn),
-		VersionStage: aws.String("AWSCURRENT"), // VersionStage defaults to AWSCURRENT if unspecified
-	}
-
-	// In this sample we only handle the specific exceptions for the 'GetSecretValue' API.
-	// See https://docs.aws.amazon.com/secretsmanager/latest/apireference/API_GetSecretValue.html
+func GetSecretByArn(svc secretsmanageriface.SecretsManagerAPI, arn string) (string, error) {
+	return GetSecretWithContext(aws.BackgroundContext(), svc, arn)
+}
 
-	result, err := svc.GetSecretValue(input)
+// GetSecretWithContext returns the current value of the secret with the given name or ARN
+func GetSecretWithContext(ctx aws.Context, svc secretsmanageriface.SecretsManagerAPI, arn string) (string, error) {
+	v, err := GetSecretVersion(ctx, svc, arn, SecretVersion{Stage: SecretStageCurrent})
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case secretsmanager.ErrCodeDecryptionFailure:
-				// Secrets Manager can't decrypt the protected secret text using the provided KMS key.
-				fmt.Println(secretsmanager.ErrCodeDecryptionFailure, aerr.Error())
-
-			case secretsmanager.ErrCodeInternalServiceError:
-				// An error occurred on the server side.
-				fmt.Println(secretsmanager.ErrCodeInternalServiceError, aerr.Error())
+		return "", err
+	}
 
-			case secretsmanager.ErrCodeInvalidParameterException:
-				// You provided an invalid value for a parameter.
-				fmt.Println(secretsmanager.ErrCodeInvalidParameterException, aerr.Error())
+	return v.String, nil
+}
 
-			case secretsmanager.ErrCodeInvalidRequestException:
-				// You provided a parameter value that is not valid for the current state of the resource.
-				fmt.Println(secretsmanager.ErrCodeInvalidRequestException, aerr.Error())
+// GetSecretVersion returns the value of the selected version of the secret with the given
+// name or ARN, along with the version id and stages of the value returned. A nil svc, or
+// SecretClient(), reads through the default provider (see SecretReader).
+func GetSecretVersion(ctx aws.Context, svc secretsmanageriface.SecretsManagerAPI, arn string, version SecretVersion) (*SecretValue, error) {
+	return getSecretVersion(ctx, secretReader(svc), arn, version)
+}
 
-			case secretsmanager.ErrCodeResourceNotFoundException:
-				// We can't find the resource that you asked for.
-				fmt.Println(secretsmanager.ErrCodeResourceNotFoundException, aerr.Error())
-			}
+func getSecretVersion(ctx aws.Context, svc secretsmanageriface.SecretsManagerAPI, arn string, version SecretVersion) (*SecretValue, error) {
+	input := &secretsmanager.GetSecretValueInput{SecretId: aws.String(arn)}
+	if version.ID != "" {
+		input.VersionId = aws.String(version.ID)
+	}
+	if version.Stage != "" {
+		input.VersionStage = aws.String(version.Stage)
+	}
 
-			return "", errors.New(aerr.Error())
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-			return "", err
+	result, err := svc.GetSecretValueWithContext(ctx, input)
+	if err != nil {
+		serr := newSecretError(arn, err)
+		if logger := currentSecretLogger(); logger != nil {
+			logger.WithFields(logrus.Fields{
+				"secret_id": arn,
+				"code":      serr.Code,
+				"error":     err,
+			}).Warn("failed to get secret value")
 		}
-
-		return "", err
+		return nil, serr
 	}
 
 	// Decrypts secret using the associated KMS key.
@@ -64,16 +61,19 @@ func GetSecretByArn(svc *secretsmanager.SecretsManager, arn string) (string, err
 		decodedBinarySecretBytes := make([]byte, base64.StdEncoding.DecodedLen(len(result.SecretBinary)))
 		len, err := base64.StdEncoding.Decode(decodedBinarySecretBytes, result.SecretBinary)
 		if err != nil {
-			fmt.Println("Base64 Decode Error:", err)
-			return "", err
+			return nil, fmt.Errorf("secret %s has an invalid binary value: %w", arn, err)
 		}
 		decodedBinarySecret = string(decodedBinarySecretBytes[:len])
 		secretString = decodedBinarySecret
 	}
 
-	return secretString, err
+	return &SecretValue{
+		String:    secretString,
+		VersionID: aws.StringValue(result.VersionId),
+		Stages:    aws.StringValueSlice(result.VersionStages),
+	}, nil
 }
 
-func GetSecret(svc *secretsmanager.SecretsManager, name string) (string, error) {
+func GetSecret(svc secretsmanageriface.SecretsManagerAPI, name string) (string, error) {
 	return GetSecretByArn(svc, name)
 }
@@ -0,0 +1,214 @@
+package services_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	klambda "github.com/kraneware/kws/lambda"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// fakeSecrets serves secret strings from memory and counts GetSecretValue calls
+type fakeSecrets struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	mu      sync.Mutex
+	secrets map[string]string
+	binary  map[string][]byte
+	stages  map[string]string
+	calls   int
+	err     error
+}
+
+func (f *fakeSecrets) set(id, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.secrets[id] = value
+}
+
+func (f *fakeSecrets) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeSecrets) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *fakeSecrets) GetSecretValueWithContext(_ aws.Context, in *secretsmanager.GetSecretValueInput, _ ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	// versions other than AWSCURRENT are looked up by id or stage in stages as "<secret>/<version>"
+	version := aws.StringValue(in.VersionId)
+	if version == "" {
+		version = aws.StringValue(in.VersionStage)
+	}
+	if version != "" && version != services.SecretStageCurrent {
+		v, ok := f.stages[*in.SecretId+"/"+version]
+		if !ok {
+			return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "version not found", nil)
+		}
+		return &secretsmanager.GetSecretValueOutput{
+			Name:          in.SecretId,
+			SecretString:  aws.String(v),
+			VersionId:     aws.String(version + "-id"),
+			VersionStages: aws.StringSlice([]string{version}),
+		}, nil
+	}
+
+	if b, ok := f.binary[*in.SecretId]; ok {
+		return &secretsmanager.GetSecretValueOutput{Name: in.SecretId, SecretBinary: b}, nil
+	}
+	v, ok := f.secrets[*in.SecretId]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+
+	return &secretsmanager.GetSecretValueOutput{
+		Name:          in.SecretId,
+		SecretString:  aws.String(v),
+		VersionId:     aws.String("current-id"),
+		VersionStages: aws.StringSlice([]string{services.SecretStageCurrent}),
+	}, nil
+}
+
+type apiKeys struct {
+	Primary   string `json:"primary" required:"true"`
+	Secondary string `json:"secondary"`
+	Partner   struct {
+		Token string `json:"token" required:"true"`
+	} `json:"partner"`
+}
+
+var _ = Describe("GetSecretInto", func() {
+	var fake *fakeSecrets
+
+	BeforeEach(func() {
+		fake = &fakeSecrets{
+			secrets: map[string]string{
+				"rds":     `{"username":"admin","password":"pw","engine":"postgres","host":"db.local","port":5432,"dbname":"app"}`,
+				"rds-str": `{"username":"admin","password":"pw","port":"3306"}`,
+				"keys":    `{"primary":"p","partner":{"token":"t"}}`,
+				"partial": `{"secondary":"s"}`,
+				"text":    "not json",
+			},
+			binary: map[string][]byte{},
+		}
+	})
+
+	It("should decode RDS credentials", func() {
+		var creds services.RDSCredentials
+		Expect(services.GetSecretInto(aws.BackgroundContext(), fake, "rds", &creds)).Should(BeNil())
+		Expect(creds).Should(Equal(services.RDSCredentials{
+			Username: "admin", Password: "pw", Engine: "postgres", Host: "db.local", Port: 5432, DBName: "app",
+		}))
+
+		creds = services.RDSCredentials{}
+		Expect(services.GetSecretInto(aws.BackgroundContext(), fake, "rds-str", &creds)).Should(BeNil())
+		Expect(creds.Port).Should(Equal(3306))
+
+		fake.set("rds-bad", `{"username":"admin","password":"pw","port":"x"}`)
+		Expect(services.GetSecretInto(aws.BackgroundContext(), fake, "rds-bad", &creds)).ShouldNot(BeNil())
+	})
+
+	It("should validate required fields", func() {
+		var keys apiKeys
+		Expect(services.GetSecretInto(aws.BackgroundContext(), fake, "keys", &keys)).Should(BeNil())
+		Expect(keys.Partner.Token).Should(Equal("t"))
+
+		err := services.GetSecretInto(aws.BackgroundContext(), fake, "partial", &apiKeys{})
+		Expect(err).ShouldNot(BeNil())
+		Expect(err.Error()).Should(ContainSubstring("primary, partner.token"))
+	})
+
+	It("should decode binary secrets", func() {
+		fake.binary["bin"] = []byte(base64.StdEncoding.EncodeToString([]byte(`{"primary":"b","partner":{"token":"t"}}`)))
+
+		var keys apiKeys
+		Expect(services.GetSecretInto(aws.BackgroundContext(), fake, "bin", &keys)).Should(BeNil())
+		Expect(keys.Primary).Should(Equal("b"))
+	})
+
+	It("should reject secrets that are not JSON", func() {
+		var m map[string]string
+		Expect(services.GetSecretInto(aws.BackgroundContext(), fake, "text", &m)).ShouldNot(BeNil())
+		Expect(services.GetSecretInto(aws.BackgroundContext(), fake, "missing", &m)).ShouldNot(BeNil())
+	})
+
+	It("should decode cached secrets", func() {
+		c := services.NewSecretCache(0)
+		c.Client = fake
+
+		var keys apiKeys
+		Expect(c.GetSecretInto(aws.BackgroundContext(), "keys", &keys)).Should(BeNil())
+		Expect(keys.Primary).Should(Equal("p"))
+		Expect(c.GetSecretInto(aws.BackgroundContext(), "missing", &keys)).ShouldNot(BeNil())
+	})
+})
+
+var _ = Describe("Secret errors", func() {
+	var fake *fakeSecrets
+
+	BeforeEach(func() {
+		fake = &fakeSecrets{secrets: map[string]string{}}
+	})
+
+	It("should keep the AWS error code", func() {
+		for code, target := range map[string]error{
+			secretsmanager.ErrCodeResourceNotFoundException: services.ErrSecretNotFound,
+			secretsmanager.ErrCodeDecryptionFailure:         services.ErrDecryptionFailure,
+			"AccessDeniedException":                         services.ErrSecretAccessDenied,
+			secretsmanager.ErrCodeInvalidRequestException:   services.ErrInvalidSecretRequest,
+			secretsmanager.ErrCodeInternalServiceError:      services.ErrSecretsManagerFailed,
+		} {
+			fake.fail(awserr.New(code, "failed", nil))
+			_, err := services.GetSecret(fake, "db")
+			Expect(errors.Is(err, target)).Should(BeTrue(), code)
+			Expect(errors.Is(err, services.ErrSecretNotFound)).Should(Equal(target == services.ErrSecretNotFound), code)
+
+			var aerr awserr.Error
+			Expect(errors.As(err, &aerr)).Should(BeTrue())
+			Expect(aerr.Code()).Should(Equal(code))
+
+			var serr *services.SecretError
+			Expect(errors.As(err, &serr)).Should(BeTrue())
+			Expect(serr.SecretID).Should(Equal("db"))
+			Expect(err.Error()).Should(ContainSubstring("secret db: " + code))
+		}
+	})
+
+	It("should log failures when a logger is set", func() {
+		base, hook := test.NewNullLogger()
+		services.SetSecretLogger(klambda.LambdaLoggerCustom(logrus.InfoLevel, base))
+		defer services.SetSecretLogger(nil)
+
+		fake.fail(awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "failed", nil))
+		_, err := services.GetSecret(fake, "db")
+		Expect(err).ShouldNot(BeNil())
+		Expect(hook.LastEntry().Data["code"]).Should(Equal(secretsmanager.ErrCodeResourceNotFoundException))
+
+		fake.fail(nil)
+		fake.binary = map[string][]byte{"bin": []byte("not base64!")}
+		_, err = services.GetSecret(fake, "bin")
+		Expect(err).ShouldNot(BeNil())
+		Expect(hook.Entries).Should(HaveLen(1))
+	})
+})
@@ -4,6 +4,9 @@ import (
 	"github.com/kraneware/kws/services"
 	"testing"
 
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -50,4 +53,50 @@ var _ = Describe("DynamoDB Util", func() {
 		})
 	})
 
+	Context("Attribute conversion", func() {
+		image := map[string]events.DynamoDBAttributeValue{
+			"s":    events.NewStringAttribute("abc"),
+			"n":    events.NewNumberAttribute("12.5"),
+			"b":    events.NewBinaryAttribute([]byte{0, 1, 2}),
+			"bool": events.NewBooleanAttribute(true),
+			"null": events.NewNullAttribute(),
+			"ss":   events.NewStringSetAttribute([]string{"a", "b"}),
+			"ns":   events.NewNumberSetAttribute([]string{"1", "2.5"}),
+			"bs":   events.NewBinarySetAttribute([][]byte{{1}, {2, 3}}),
+			"l": events.NewListAttribute([]events.DynamoDBAttributeValue{
+				events.NewStringAttribute("x"),
+				events.NewNullAttribute(),
+			}),
+			"m": events.NewMapAttribute(map[string]events.DynamoDBAttributeValue{
+				"inner": events.NewNullAttribute(),
+				"ns":    events.NewNumberSetAttribute([]string{"3"}),
+			}),
+		}
+
+		It("should convert every attribute type", func() {
+			m := services.StreamImageToAttributeMap(image)
+			Expect(*m["s"].S).Should(Equal("abc"))
+			Expect(*m["n"].N).Should(Equal("12.5"))
+			Expect(m["b"].B).Should(Equal([]byte{0, 1, 2}))
+			Expect(*m["bool"].BOOL).Should(BeTrue())
+			Expect(*m["null"].NULL).Should(BeTrue())
+			Expect(aws.StringValueSlice(m["ss"].SS)).Should(Equal([]string{"a", "b"}))
+			Expect(aws.StringValueSlice(m["ns"].NS)).Should(Equal([]string{"1", "2.5"}))
+			Expect(m["bs"].BS).Should(Equal([][]byte{{1}, {2, 3}}))
+			Expect(*m["l"].L[1].NULL).Should(BeTrue())
+			Expect(*m["m"].M["inner"].NULL).Should(BeTrue())
+			Expect(aws.StringValueSlice(m["m"].M["ns"].NS)).Should(Equal([]string{"3"}))
+		})
+
+		It("should round trip without loss", func() {
+			back, err := services.AttributeMapToStreamImage(services.StreamImageToAttributeMap(image))
+			Expect(err).Should(BeNil())
+			Expect(back).Should(Equal(image))
+		})
+
+		It("should reject empty attribute values", func() {
+			_, err := services.AttributeMapToStreamImage(map[string]*dynamodb.AttributeValue{"a": {}})
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
 })
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/kraneware/kws/internal/cache"
+)
+
+// ErrParameterNotFound is matched with errors.Is by the errors of missing parameters
+var ErrParameterNotFound = errors.New("parameter not found")
+
+// maxGetParameters is the number of names accepted by a single GetParameters call
+const maxGetParameters = 10
+
+// GetParameter returns the value of the named parameter, decrypting SecureString values. A nil
+// svc, or SSMClient(), reads through the default provider (see ParameterReader); so do the
+// other parameter helpers.
+func GetParameter(ctx aws.Context, svc ssmiface.SSMAPI, name string) (string, error) {
+	out, err := parameterReader(svc).GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		return "", fmt.Errorf("%w: %s", ErrParameterNotFound, name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+// GetParameters returns the values of the named parameters by name, decrypting SecureString
+// values. Any number of names may be given; an error lists the names that do not exist.
+func GetParameters(ctx aws.Context, svc ssmiface.SSMAPI, names []string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	svc = parameterReader(svc)
+
+	var invalid []string
+	for start := 0; start < len(names); start += maxGetParameters {
+		end := start + maxGetParameters
+		if end > len(names) {
+			end = len(names)
+		}
+
+		out, err := svc.GetParametersWithContext(ctx, &ssm.GetParametersInput{
+			Names:          aws.StringSlice(names[start:end]),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range out.Parameters {
+			values[aws.StringValue(p.Name)] = aws.StringValue(p.Value)
+		}
+		invalid = append(invalid, aws.StringValueSlice(out.InvalidParameters)...)
+	}
+
+	if len(invalid) > 0 {
+		return values, fmt.Errorf("%w: %s", ErrParameterNotFound, strings.Join(invalid, ", "))
+	}
+
+	return values, nil
+}
+
+// GetParametersByPath returns the values of every parameter below path by full name,
+// decrypting SecureString values
+func GetParametersByPath(ctx aws.Context, svc ssmiface.SSMAPI, path string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	err := parameterReader(svc).GetParametersByPathPagesWithContext(ctx, &ssm.GetParametersByPathInput{
+		Path:           aws.String(parameterPath(path)),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}, func(out *ssm.GetParametersByPathOutput, _ bool) bool {
+		for _, p := range out.Parameters {
+			values[aws.StringValue(p.Name)] = aws.StringValue(p.Value)
+		}
+		return true
+	})
+
+	return values, err
+}
+
+// LoadParameters fills the struct pointed to by out from the parameters below path, see
+// ParameterStore.Load. Parameters are cached for five minutes across warm invocations.
+func LoadParameters(ctx aws.Context, path string, out interface{}) error {
+	return defaultParameters().Load(ctx, path, out)
+}
+
+// nolint:gochecknoglobals
+var (
+	parameterStore     *ParameterStore
+	parameterStoreInit sync.Once
+)
+
+func defaultParameters() *ParameterStore {
+	parameterStoreInit.Do(func() {
+		parameterStore = NewParameterStore(5 * time.Minute)
+	})
+
+	return parameterStore
+}
+
+// ParameterStore reads SSM parameters and keeps them for TTL, so that warm Lambda invocations
+// do not call SSM again
+//
+// Example:
+//
+//	type config struct {
+//		Host    string        `ssm:"db/host" required:"true"`
+//		Port    int           `ssm:"db/port"`
+//		Timeout time.Duration `ssm:"timeout"`
+//		Hosts   []string      `ssm:"hosts"`
+//		Queue   struct {
+//			URL string `ssm:"url"`
+//		} `ssm:"queue"`
+//	}
+//	var cfg config
+//	err := services.NewParameterStore(time.Minute).Load(ctx, "/myapp/prod/", &cfg)
+type ParameterStore struct {
+	TTL time.Duration
+
+	// Client defaults to ParameterReader() when nil
+	Client ssmiface.SSMAPI
+
+	mu      sync.Mutex
+	entries map[string]*cachedParameters
+	flight  cache.Group
+}
+
+type cachedParameters struct {
+	values  map[string]string
+	fetched time.Time
+}
+
+// NewParameterStore creates a store caching parameters for ttl
+func NewParameterStore(ttl time.Duration) *ParameterStore {
+	return &ParameterStore{TTL: ttl}
+}
+
+func (s *ParameterStore) client() ssmiface.SSMAPI {
+	if s.Client != nil {
+		return s.Client
+	}
+
+	return ParameterReader()
+}
+
+// Get returns the value of the named parameter
+func (s *ParameterStore) Get(ctx aws.Context, name string) (string, error) {
+	values, err := s.cached(name, func() (map[string]string, error) {
+		v, err := GetParameter(ctx, s.client(), name)
+		return map[string]string{name: v}, err
+	})
+
+	return values[name], err
+}
+
+// GetByPath returns the values of every parameter below path by full name
+func (s *ParameterStore) GetByPath(ctx aws.Context, path string) (map[string]string, error) {
+	path = parameterPath(path)
+
+	return s.cached(path+"/", func() (map[string]string, error) {
+		return GetParametersByPath(ctx, s.client(), path)
+	})
+}
+
+// Load fills the struct pointed to by out from the parameters below path. Fields are matched
+// on their ssm tag, the parameter name relative to path; a struct field tagged with a name
+// reads the parameters below that name. Values are converted to the field type, with
+// StringList parameters read into slices, and fields tagged `required:"true"` must have a
+// parameter.
+func (s *ParameterStore) Load(ctx aws.Context, path string, out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot load parameters into %T, a struct pointer is required", out)
+	}
+
+	values, err := s.GetByPath(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	prefix := parameterPath(path)
+	if prefix != "/" {
+		prefix += "/"
+	}
+
+	var missing []string
+	if err = loadParameters(v.Elem(), prefix, values, &missing); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrParameterNotFound, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Invalidate drops every cached parameter
+func (s *ParameterStore) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = nil
+}
+
+func (s *ParameterStore) cached(key string, fetch func() (map[string]string, error)) (map[string]string, error) {
+	s.mu.Lock()
+	e, ok := s.entries[key]
+	s.mu.Unlock()
+	if ok && time.Since(e.fetched) < s.TTL {
+		return e.values, nil
+	}
+
+	v, err := s.flight.Do(key, func() (interface{}, error) {
+		values, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.entries == nil {
+			s.entries = make(map[string]*cachedParameters)
+		}
+		s.entries[key] = &cachedParameters{values: values, fetched: time.Now()}
+
+		return values, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(map[string]string), nil
+}
+
+func loadParameters(v reflect.Value, prefix string, values map[string]string, missing *[]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("ssm")
+		if name == "" || f.PkgPath != "" {
+			continue
+		}
+
+		name = prefix + strings.Trim(name, "/")
+		fv := v.Field(i)
+
+		raw, ok := values[name]
+		if !ok && fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			if err := loadParameters(fv, name+"/", values, missing); err != nil {
+				return err
+			}
+			continue
+		}
+		if !ok {
+			if f.Tag.Get("required") == "true" {
+				*missing = append(*missing, name)
+			}
+			continue
+		}
+
+		if err := setFromString(fv, raw); err != nil {
+			return fmt.Errorf("parameter %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func parameterPath(path string) string {
+	if path == "/" {
+		return path
+	}
+
+	return "/" + strings.Trim(path, "/")
+}
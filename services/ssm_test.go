@@ -0,0 +1,243 @@
+package services_test
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/kraneware/kws/services"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSSM serves parameters from memory, returning two parameters per GetParametersByPath page
+type fakeSSM struct {
+	ssmiface.SSMAPI
+
+	mu     sync.Mutex
+	params map[string]string
+	calls  map[string]int
+}
+
+func (f *fakeSSM) count(call string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[call]
+}
+
+func (f *fakeSSM) parameter(name string) *ssm.Parameter {
+	return &ssm.Parameter{Name: aws.String(name), Value: aws.String(f.params[name])}
+}
+
+func (f *fakeSSM) GetParameterWithContext(_ aws.Context, in *ssm.GetParameterInput, _ ...request.Option) (*ssm.GetParameterOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetParameter"]++
+
+	Expect(*in.WithDecryption).Should(BeTrue())
+	if _, ok := f.params[*in.Name]; !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+	}
+
+	return &ssm.GetParameterOutput{Parameter: f.parameter(*in.Name)}, nil
+}
+
+func (f *fakeSSM) GetParametersWithContext(_ aws.Context, in *ssm.GetParametersInput, _ ...request.Option) (*ssm.GetParametersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetParameters"]++
+
+	Expect(len(in.Names)).Should(BeNumerically("<=", 10))
+	out := &ssm.GetParametersOutput{}
+	for _, n := range aws.StringValueSlice(in.Names) {
+		if _, ok := f.params[n]; ok {
+			out.Parameters = append(out.Parameters, f.parameter(n))
+		} else {
+			out.InvalidParameters = append(out.InvalidParameters, aws.String(n))
+		}
+	}
+
+	return out, nil
+}
+
+func (f *fakeSSM) GetParametersByPathPagesWithContext(_ aws.Context, in *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool, _ ...request.Option) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetParametersByPath"]++
+
+	if strings.HasSuffix(*in.Path, "/") && *in.Path != "/" {
+		return awserr.New("ValidationException", "invalid path", nil)
+	}
+
+	var names []string
+	for n := range f.params {
+		if strings.HasPrefix(n, strings.TrimSuffix(*in.Path, "/")+"/") {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	for start := 0; start < len(names) || start == 0; start += 2 {
+		out := &ssm.GetParametersByPathOutput{}
+		for _, n := range names[start:min(start+2, len(names))] {
+			out.Parameters = append(out.Parameters, f.parameter(n))
+		}
+		if !fn(out, start+2 >= len(names)) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type queueConfig struct {
+	URL     string `ssm:"url" required:"true"`
+	Batch   int    `ssm:"batch"`
+	Enabled bool   `ssm:"enabled"`
+}
+
+type appConfig struct {
+	Host     string            `ssm:"db/host" required:"true"`
+	Port     uint16            `ssm:"db/port"`
+	Timeout  time.Duration     `ssm:"timeout"`
+	Ratio    float64           `ssm:"ratio"`
+	Hosts    []string          `ssm:"hosts"`
+	Ports    []int             `ssm:"ports"`
+	Limit    *int              `ssm:"limit"`
+	Labels   map[string]string `ssm:"labels"`
+	Queue    queueConfig       `ssm:"queue"`
+	Optional string            `ssm:"optional"`
+	Ignored  string
+}
+
+var _ = Describe("SSM parameters", func() {
+	var fake *fakeSSM
+
+	BeforeEach(func() {
+		fake = &fakeSSM{
+			params: map[string]string{
+				"/app/prod/db/host":       "db.local",
+				"/app/prod/db/port":       "5432",
+				"/app/prod/timeout":       "30s",
+				"/app/prod/ratio":         "0.5",
+				"/app/prod/hosts":         "a, b,c",
+				"/app/prod/ports":         "1,2",
+				"/app/prod/limit":         "7",
+				"/app/prod/labels":        `{"team":"core"}`,
+				"/app/prod/queue/url":     "https://sqs/q",
+				"/app/prod/queue/batch":   "10",
+				"/app/prod/queue/enabled": "true",
+				"/app/dev/db/port":        "x",
+			},
+			calls: make(map[string]int),
+		}
+	})
+
+	It("should get single parameters", func() {
+		Expect(services.GetParameter(aws.BackgroundContext(), fake, "/app/prod/db/host")).Should(Equal("db.local"))
+
+		_, err := services.GetParameter(aws.BackgroundContext(), fake, "/app/missing")
+		Expect(errors.Is(err, services.ErrParameterNotFound)).Should(BeTrue())
+	})
+
+	It("should get parameters in chunks of ten", func() {
+		names := []string{"/app/missing"}
+		for i := 0; i < 12; i++ {
+			name := fmt.Sprintf("/app/n%02d", i)
+			fake.params[name] = name
+			names = append(names, name)
+		}
+
+		values, err := services.GetParameters(aws.BackgroundContext(), fake, names)
+		Expect(errors.Is(err, services.ErrParameterNotFound)).Should(BeTrue())
+		Expect(err.Error()).Should(ContainSubstring("/app/missing"))
+		Expect(values).Should(HaveLen(12))
+		Expect(fake.count("GetParameters")).Should(Equal(2))
+
+		values, err = services.GetParameters(aws.BackgroundContext(), fake, names[1:])
+		Expect(err).Should(BeNil())
+		Expect(values["/app/n11"]).Should(Equal("/app/n11"))
+	})
+
+	It("should get every page of a path", func() {
+		values, err := services.GetParametersByPath(aws.BackgroundContext(), fake, "/app/prod/")
+		Expect(err).Should(BeNil())
+		Expect(values).Should(HaveLen(11))
+		Expect(values["/app/prod/queue/url"]).Should(Equal("https://sqs/q"))
+	})
+
+	It("should load a hierarchy into a struct", func() {
+		store := services.NewParameterStore(time.Minute)
+		store.Client = fake
+
+		var cfg appConfig
+		Expect(store.Load(aws.BackgroundContext(), "/app/prod/", &cfg)).Should(BeNil())
+
+		limit := 7
+		Expect(cfg).Should(Equal(appConfig{
+			Host:    "db.local",
+			Port:    5432,
+			Timeout: 30 * time.Second,
+			Ratio:   0.5,
+			Hosts:   []string{"a", "b", "c"},
+			Ports:   []int{1, 2},
+			Limit:   &limit,
+			Labels:  map[string]string{"team": "core"},
+			Queue:   queueConfig{URL: "https://sqs/q", Batch: 10, Enabled: true},
+		}))
+	})
+
+	It("should report missing and invalid parameters", func() {
+		store := services.NewParameterStore(time.Minute)
+		store.Client = fake
+
+		var cfg appConfig
+		err := store.Load(aws.BackgroundContext(), "/app/dev", &cfg)
+		Expect(err).ShouldNot(BeNil())
+		Expect(err.Error()).Should(ContainSubstring("/app/dev/db/port"))
+
+		delete(fake.params, "/app/dev/db/port")
+		store.Invalidate()
+		err = store.Load(aws.BackgroundContext(), "/app/dev", &cfg)
+		Expect(errors.Is(err, services.ErrParameterNotFound)).Should(BeTrue())
+		Expect(err.Error()).Should(ContainSubstring("/app/dev/db/host, /app/dev/queue/url"))
+
+		Expect(store.Load(aws.BackgroundContext(), "/app/dev", cfg)).ShouldNot(BeNil())
+	})
+
+	It("should cache parameters for the TTL", func() {
+		store := services.NewParameterStore(50 * time.Millisecond)
+		store.Client = fake
+
+		var cfg appConfig
+		for i := 0; i < 3; i++ {
+			Expect(store.Load(aws.BackgroundContext(), "/app/prod", &cfg)).Should(BeNil())
+			Expect(store.Get(aws.BackgroundContext(), "/app/prod/db/host")).Should(Equal("db.local"))
+		}
+		Expect(fake.count("GetParametersByPath")).Should(Equal(1))
+		Expect(fake.count("GetParameter")).Should(Equal(1))
+
+		fake.params["/app/prod/db/host"] = "db2.local"
+		time.Sleep(60 * time.Millisecond)
+		Expect(store.Load(aws.BackgroundContext(), "/app/prod", &cfg)).Should(BeNil())
+		Expect(cfg.Host).Should(Equal("db2.local"))
+
+		_, err := store.Get(aws.BackgroundContext(), "/app/missing")
+		Expect(errors.Is(err, services.ErrParameterNotFound)).Should(BeTrue())
+	})
+})